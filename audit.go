@@ -0,0 +1,415 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bufio`
+	`bytes`
+	`crypto/ed25519`
+	`crypto/hmac`
+	`crypto/sha256`
+	`encoding/base64`
+	`encoding/hex`
+	`encoding/json`
+	`fmt`
+	`hash`
+	`io`
+	`io/ioutil`
+	`os`
+	`strings`
+	`sync`
+	`time`
+)
+
+const (
+	AuditTypeRecord = ``
+	AuditTypeCheckpoint = `checkpoint`
+)
+
+// AuditGenesis is the previous-hash value of the first record in a chain.
+var AuditGenesis = strings.Repeat(`0`, sha256.Size * 2)
+
+// AuditRecord is one line of an audit log. Each record's Hash covers its
+// sequence number, time, type, message and the previous record's hash, so
+// altering, removing or reordering records breaks the chain. Checkpoint
+// records also carry an Ed25519 signature of their hash.
+type AuditRecord struct {
+	Seq uint64 `json:"seq"`
+	Time string `json:"time"`
+	Type string `json:"type,omitempty"`
+	Msg string `json:"msg,omitempty"`
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+	Sig string `json:"sig,omitempty"`
+}
+
+// AuditWriter is an io.Writer that turns each line written to it into a
+// hash-chained AuditRecord encoded as a line of JSON.
+type AuditWriter struct {
+	mu sync.Mutex
+	w io.Writer
+	key []byte
+	signer ed25519.PrivateKey
+	every uint64
+	since uint64
+	seq uint64
+	prev string
+}
+
+// NewAuditWriter returns an AuditWriter that writes records to w. If key
+// is not empty, record hashes are HMAC-SHA256 rather than SHA-256. If
+// signer is not nil, a signed checkpoint record is written after every
+// 'every' records.
+func NewAuditWriter(w io.Writer, key []byte, signer ed25519.PrivateKey, every int) (this *AuditWriter) {
+
+	this = &AuditWriter{
+		w: w,
+		key: key,
+		signer: signer,
+		prev: AuditGenesis,
+	}
+
+	if every > 0 {
+		this.every = uint64(every)
+	}
+
+	return this
+}
+
+// Resume continues the chain from the last record read from r, typically
+// the existing audit log file being appended to.
+func (this *AuditWriter) Resume(r io.Reader) (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024 * 1024)
+
+	for sc.Scan() {
+
+		var rec AuditRecord
+
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		if err = json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return err
+		}
+
+		this.seq, this.prev = rec.Seq, rec.Hash
+	}
+
+	return sc.Err()
+}
+
+// ResumeFile continues the chain from the last record in the named file.
+// A missing file is not an error.
+func (this *AuditWriter) ResumeFile(fn string) (err error) {
+
+	fh, err := os.Open(fn)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	return this.Resume(fh)
+}
+
+// Write appends one record per line in b.
+func (this *AuditWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// The records are not in the log unless the write succeeds, so the
+	// chain must not advance past them if it fails.
+
+	seq, prev, since := this.seq, this.prev, this.since
+
+	bb := new(bytes.Buffer)
+	je := json.NewEncoder(bb)
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+
+		je.Encode(this.next(AuditTypeRecord, line))
+
+		if this.signer != nil && this.every > 0 && this.since >= this.every {
+			je.Encode(this.next(AuditTypeCheckpoint, ``))
+		}
+	}
+
+	if _, err = this.w.Write(bb.Bytes()); err != nil {
+		this.seq, this.prev, this.since = seq, prev, since
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Checkpoint writes a signed checkpoint record immediately.
+func (this *AuditWriter) Checkpoint() (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.signer == nil {
+		return fmt.Errorf(`audit checkpoint requires a signing key`)
+	}
+
+	seq, prev, since := this.seq, this.prev, this.since
+
+	b, err := json.Marshal(this.next(AuditTypeCheckpoint, ``))
+
+	if err == nil {
+		_, err = this.w.Write(append(b, '\n'))
+	}
+
+	if err != nil {
+		this.seq, this.prev, this.since = seq, prev, since
+	}
+
+	return err
+}
+
+// next builds the next record in the chain. The caller must hold the lock.
+func (this *AuditWriter) next(typ, msg string) *AuditRecord {
+
+	this.seq++
+
+	rec := &AuditRecord{
+		Seq: this.seq,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		Type: typ,
+		Msg: msg,
+		Prev: this.prev,
+	}
+
+	rec.Hash = AuditHash(rec, this.key)
+
+	if typ == AuditTypeCheckpoint {
+		sum, _ := hex.DecodeString(rec.Hash)
+		rec.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(this.signer, sum))
+		this.since = 0
+	} else {
+		this.since++
+	}
+
+	this.prev = rec.Hash
+
+	return rec
+}
+
+// AuditHash computes the hash of a record. If key is not empty the hash
+// is HMAC-SHA256 keyed with it, otherwise plain SHA-256.
+func AuditHash(rec *AuditRecord, key []byte) string {
+
+	var h hash.Hash
+
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%s", rec.Seq, rec.Time, rec.Type, rec.Msg, rec.Prev)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ReadAuditKey reads a hex-encoded key from a file. Surrounding
+// whitespace is ignored.
+func ReadAuditKey(fn string) (key []byte, err error) {
+
+	b, err := ioutil.ReadFile(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(strings.TrimSpace(string(b)))
+}
+
+// ReadAuditSigningKey reads a hex-encoded Ed25519 seed or private key
+// from a file.
+func ReadAuditSigningKey(fn string) (key ed25519.PrivateKey, err error) {
+
+	b, err := ReadAuditKey(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf(`signing key in %q has invalid length %d`, fn, len(b))
+	}
+}
+
+// AuditError describes the first record of an audit log that failed
+// verification.
+type AuditError struct {
+	Line int
+	Seq uint64
+	Reason string
+}
+
+// Error implements the error interface.
+func (this *AuditError) Error() string {
+	return fmt.Sprintf(`line %d: seq %d: %s`, this.Line, this.Seq, this.Reason)
+}
+
+// AuditReport summarizes a verified audit log. Unanchored is set when
+// the log starts after sequence 1 and no anchor was given, so records
+// removed from its start cannot be detected.
+type AuditReport struct {
+	Records uint64
+	Checkpoints uint64
+	FirstSeq uint64
+	LastSeq uint64
+	LastHash string
+	Unanchored bool
+}
+
+// AuditAnchor is the record an audit log is expected to continue from:
+// typically the last record of the previous file, as reported in its
+// AuditReport. The zero value anchors the log at the genesis.
+type AuditAnchor struct {
+	Seq uint64
+	Hash string
+}
+
+// ParseAuditAnchor parses an anchor written as "seq:hash".
+func ParseAuditAnchor(s string) (a *AuditAnchor, err error) {
+
+	i := strings.IndexByte(s, ':')
+
+	if i < 0 {
+		return nil, fmt.Errorf(`invalid audit anchor %q: want seq:hash`, s)
+	}
+
+	a = &AuditAnchor{Hash: s[i+1:]}
+
+	if _, err = fmt.Sscan(s[:i], &a.Seq); err != nil {
+		return nil, fmt.Errorf(`invalid audit anchor %q: %v`, s, err)
+	}
+
+	return a, nil
+}
+
+// VerifyAuditLog verifies an audit log without an anchor; see
+// VerifyAuditLogFrom.
+func VerifyAuditLog(r io.Reader, key []byte, pub ed25519.PublicKey) (rpt *AuditReport, err error) {
+	return VerifyAuditLogFrom(r, key, pub, nil)
+}
+
+// VerifyAuditLogFrom walks an audit log and checks the sequence, the hash
+// of every record, the link to the previous record and, if pub is not
+// nil, every checkpoint signature. It stops at the first broken or
+// missing entry and returns an *AuditError describing it. The first
+// record must continue from anchor if one is given and from the genesis
+// if it has sequence 1. A log that starts later without an anchor, such
+// as one rotated from an earlier file, is verified from its first record
+// onward and reported as Unanchored.
+func VerifyAuditLogFrom(r io.Reader, key []byte, pub ed25519.PublicKey, anchor *AuditAnchor) (rpt *AuditReport, err error) {
+
+	rpt = new(AuditReport)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024 * 1024)
+
+	var (
+		line int
+		prev string
+		last uint64
+		first = true
+	)
+
+	if anchor != nil {
+		if last, prev = anchor.Seq, anchor.Hash; last == 0 {
+			prev = AuditGenesis
+		}
+	}
+
+	for sc.Scan() {
+
+		line++
+
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		var rec AuditRecord
+
+		if err = json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return rpt, &AuditError{line, last + 1, fmt.Sprintf(`malformed record: %v`, err)}
+		}
+
+		switch {
+		case first && anchor == nil && rec.Seq == 1 && rec.Prev != AuditGenesis:
+			return rpt, &AuditError{line, rec.Seq, `first record does not start from genesis`}
+		case first && anchor != nil && rec.Seq != last + 1:
+			return rpt, &AuditError{line, rec.Seq, fmt.Sprintf(`does not continue from anchor seq %d`, last)}
+		case first && anchor != nil && rec.Prev != prev:
+			return rpt, &AuditError{line, rec.Seq, `previous hash does not match anchor`}
+		case !first && rec.Seq <= last:
+			return rpt, &AuditError{line, rec.Seq, fmt.Sprintf(`out of order after seq %d`, last)}
+		case !first && rec.Seq != last + 1:
+			return rpt, &AuditError{line, last + 1, fmt.Sprintf(`missing records %d-%d`, last + 1, rec.Seq - 1)}
+		case !first && rec.Prev != prev:
+			return rpt, &AuditError{line, rec.Seq, `previous hash does not match`}
+		case AuditHash(&rec, key) != rec.Hash:
+			return rpt, &AuditError{line, rec.Seq, `hash does not match contents`}
+		}
+
+		if rec.Type == AuditTypeCheckpoint {
+
+			if pub != nil {
+
+				sum, _ := hex.DecodeString(rec.Hash)
+				sig, err := base64.StdEncoding.DecodeString(rec.Sig)
+
+				if err != nil || !ed25519.Verify(pub, sum, sig) {
+					return rpt, &AuditError{line, rec.Seq, `invalid checkpoint signature`}
+				}
+			}
+
+			rpt.Checkpoints++
+
+		} else {
+			rpt.Records++
+		}
+
+		if first {
+			rpt.FirstSeq = rec.Seq
+			rpt.Unanchored = anchor == nil && rec.Seq != 1
+			first = false
+		}
+
+		last, prev = rec.Seq, rec.Hash
+		rpt.LastSeq, rpt.LastHash = last, prev
+	}
+
+	return rpt, sc.Err()
+}
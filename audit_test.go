@@ -0,0 +1,179 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`crypto/ed25519`
+	`errors`
+	`fmt`
+	`strings`
+	`testing`
+)
+
+// auditLines writes n records through an AuditWriter and returns the
+// resulting lines.
+func auditLines(t *testing.T, n int, key []byte) []string {
+
+	bb := new(bytes.Buffer)
+	aw := NewAuditWriter(bb, key, nil, 0)
+
+	for i := 1; i <= n; i++ {
+		if _, err := fmt.Fprintf(aw, "message %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return strings.SplitAfter(strings.TrimSuffix(bb.String(), "\n"), "\n")
+}
+
+func TestVerifyAuditLogIntact(t *testing.T) {
+
+	key := []byte(`secret`)
+	lines := auditLines(t, 5, key)
+
+	rpt, err := VerifyAuditLog(strings.NewReader(strings.Join(lines, ``)), key, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rpt.Unanchored || rpt.Records != 5 || rpt.FirstSeq != 1 || rpt.LastSeq != 5 {
+		t.Errorf(`unexpected report %+v`, rpt)
+	}
+}
+
+func TestVerifyAuditLogHeadDeleted(t *testing.T) {
+
+	key := []byte(`secret`)
+	lines := auditLines(t, 5, key)
+	tail := strings.Join(lines[2:], ``)
+
+	// Without an anchor the truncation cannot be proven, but it must not
+	// pass as an intact log.
+
+	rpt, err := VerifyAuditLog(strings.NewReader(tail), key, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !rpt.Unanchored || rpt.FirstSeq != 3 {
+		t.Errorf(`head deletion not reported: %+v`, rpt)
+	}
+
+	// Anchored at the genesis, as the first file of a chain is, the
+	// missing records are an error.
+
+	if _, err = VerifyAuditLogFrom(strings.NewReader(tail), key, nil, &AuditAnchor{}); err == nil {
+		t.Error(`head deletion accepted with genesis anchor`)
+	}
+
+	// Anchored at the last record of the previous file, it verifies only
+	// if nothing between the two was removed.
+
+	head, err := VerifyAuditLog(strings.NewReader(strings.Join(lines[:2], ``)), key, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anchor := &AuditAnchor{Seq: head.LastSeq, Hash: head.LastHash}
+
+	if rpt, err = VerifyAuditLogFrom(strings.NewReader(tail), key, nil, anchor); err != nil {
+		t.Errorf(`anchored tail rejected: %v`, err)
+	} else if rpt.Unanchored {
+		t.Errorf(`anchored tail reported unanchored`)
+	}
+
+	cut := strings.Join(lines[3:], ``)
+
+	if _, err = VerifyAuditLogFrom(strings.NewReader(cut), key, nil, anchor); err == nil {
+		t.Error(`head deletion accepted with previous-file anchor`)
+	}
+}
+
+func TestParseAuditAnchor(t *testing.T) {
+
+	a, err := ParseAuditAnchor(`42:abcd`)
+
+	if err != nil || a.Seq != 42 || a.Hash != `abcd` {
+		t.Errorf(`got %+v, %v`, a, err)
+	}
+
+	if _, err = ParseAuditAnchor(`abcd`); err == nil {
+		t.Error(`anchor without seq accepted`)
+	}
+}
+
+// flakyWriter fails writes while fail is set.
+type flakyWriter struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (this *flakyWriter) Write(b []byte) (int, error) {
+
+	if this.fail {
+		return 0, errTestSink
+	}
+
+	return this.Buffer.Write(b)
+}
+
+func TestAuditWriterFailedWrite(t *testing.T) {
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte(`secret`)
+	fw := new(flakyWriter)
+	aw := NewAuditWriter(fw, key, priv, 2)
+
+	// A failed write, with or without a checkpoint due, and a failed
+	// explicit checkpoint leave the chain as it was.
+
+	for i, fail := range []bool{false, true, false, true, false, false} {
+
+		var want error
+
+		if fw.fail = fail; fail {
+			want = errTestSink
+		}
+
+		if _, err := fmt.Fprintf(aw, "message %d\n", i); !errors.Is(err, want) {
+			t.Fatalf(`write %d: got %v, want %v`, i, err, want)
+		}
+
+		if fail {
+			if err := aw.Checkpoint(); !errors.Is(err, errTestSink) {
+				t.Fatalf(`checkpoint %d: got %v, want %v`, i, err, errTestSink)
+			}
+		}
+	}
+
+	rpt, err := VerifyAuditLogFrom(strings.NewReader(fw.String()), key, pub, &AuditAnchor{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rpt.Records != 4 || rpt.Checkpoints != 2 || rpt.LastSeq != 6 {
+		t.Errorf(`unexpected report %+v`, rpt)
+	}
+}
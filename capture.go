@@ -0,0 +1,331 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`errors`
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`os/exec`
+	`strings`
+	`sync`
+)
+
+// LineWriter is an io.Writer that splits its input into lines and passes
+// each complete line, without the line ending, to a function. A final
+// line without a line ending is held until more input arrives or Close
+// is called.
+type LineWriter struct {
+	mu sync.Mutex
+	fn func(line string)
+	buf []byte
+}
+
+// NewLineWriter returns a LineWriter that calls fn for each line.
+func NewLineWriter(fn func(line string)) (this *LineWriter) {
+	return &LineWriter{fn: fn}
+}
+
+// Write passes each complete line in b to the line function. The lines
+// are collected under the lock and passed on after it is released, so the
+// line function may itself write to the LineWriter.
+func (this *LineWriter) Write(b []byte) (n int, err error) {
+
+	var lines []string
+
+	this.mu.Lock()
+
+	this.buf = append(this.buf, b...)
+
+	for {
+		i := bytes.IndexByte(this.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(string(this.buf[:i]), "\r"))
+		this.buf = this.buf[i+1:]
+	}
+
+	this.mu.Unlock()
+
+	for _, line := range lines {
+		this.fn(line)
+	}
+
+	return len(b), nil
+}
+
+// Close passes any unfinished line to the line function.
+func (this *LineWriter) Close() error {
+
+	this.mu.Lock()
+	buf := this.buf
+	this.buf = nil
+	this.mu.Unlock()
+
+	if len(buf) > 0 {
+		this.fn(strings.TrimSuffix(string(buf), "\r"))
+	}
+
+	return nil
+}
+
+// lineLogger returns a function that writes a line to the logger of a
+// channel, preceded by tag if one is given.
+func (this *MultiLoggerWriter) lineLogger(c LogChannel, tag string) func(string) {
+
+	logger := this.GetLogger(c)
+
+	if tag != `` {
+		tag += `: `
+	}
+
+	return func(line string) {
+		logger.Print(tag + line)
+	}
+}
+
+// RedirectStdLog sends the output of the standard library's global logger
+// to a channel, so that messages from packages that call log.Printf get
+// the channel's prefix and sinks. The returned function restores the
+// previous output, prefix and flags.
+//
+// While the redirect is in effect, errors that MultiLoggerWriter itself
+// cannot return are written to the global logger's previous output rather
+// than to the channel, which may be the one failing.
+func (this *MultiLoggerWriter) RedirectStdLog(c LogChannel) (restore func()) {
+
+	w, prefix, flags := log.Writer(), log.Prefix(), log.Flags()
+
+	prev := setErrorLogger(log.New(w, prefix, flags))
+
+	log.SetOutput(NewLineWriter(this.lineLogger(c, ``)))
+	log.SetPrefix(``)
+	log.SetFlags(0)
+
+	return func() {
+		log.SetOutput(w)
+		log.SetPrefix(prefix)
+		log.SetFlags(flags)
+		setErrorLogger(prev)
+	}
+}
+
+// errNoRedirect is returned by dupFD on platforms that cannot redirect
+// file descriptors.
+var errNoRedirect = errors.New(`descriptor redirection not supported`)
+
+// stdio holds duplicates of the original standard output and error
+// descriptors, keyed by descriptor, while CaptureStdio has redirected
+// them.
+var stdio = struct {
+	sync.RWMutex
+	orig map[uintptr]*os.File
+}{orig: make(map[uintptr]*os.File)}
+
+// stdioFile is a console sink for standard output or error. While
+// CaptureStdio has redirected the file's descriptor it writes to the
+// original one instead, so that console output is not captured into the
+// channel it came from.
+type stdioFile struct {
+	f *os.File
+	fd uintptr
+}
+
+// newStdioFile returns a stdioFile for f.
+func newStdioFile(f *os.File) *stdioFile {
+	return &stdioFile{f: f, fd: f.Fd()}
+}
+
+// Write writes b to the file or, while it is captured, to the original
+// descriptor.
+func (this *stdioFile) Write(b []byte) (n int, err error) {
+
+	stdio.RLock()
+	defer stdio.RUnlock()
+
+	if orig := stdio.orig[this.fd]; orig != nil {
+		return orig.Write(b)
+	}
+
+	return this.f.Write(b)
+}
+
+// CaptureStdio sends everything written to standard output and standard
+// error line by line to the given channels. Where the platform supports
+// it, file descriptors 1 and 2 are redirected to pipes, so output from
+// child processes, C code and the Go runtime is captured along with
+// output written through os.Stdout and os.Stderr; elsewhere only the
+// os.Stdout and os.Stderr variables are replaced. Output written just
+// before the process crashes may be lost, as the process exits before it
+// is read. Console sinks created by Init keep writing to the original
+// files. The returned function restores the original descriptors and
+// waits for the captured output to be logged, which includes waiting for
+// child processes that inherited them to exit.
+func (this *MultiLoggerWriter) CaptureStdio(stdout, stderr LogChannel) (restore func() error, err error) {
+
+	type capture struct {
+		file **os.File
+		c LogChannel
+		tag string
+		fd uintptr
+		orig *os.File
+		saved *os.File
+		w *os.File
+		done chan struct{}
+	}
+
+	caps := []*capture{
+		{file: &os.Stdout, c: stdout, tag: `stdout`},
+		{file: &os.Stderr, c: stderr, tag: `stderr`},
+	}
+
+	// release undoes a capture: it points the descriptor or variable back
+	// at the original file and waits for the reader to drain the pipe.
+
+	var release = func(cp *capture) (err error) {
+
+		if cp.saved != nil {
+			stdio.Lock()
+			err = redirectFD(int(cp.saved.Fd()), int(cp.fd))
+			delete(stdio.orig, cp.fd)
+			stdio.Unlock()
+			cp.saved.Close()
+		} else {
+			*cp.file = cp.orig
+			err = cp.w.Close()
+		}
+
+		<-cp.done
+
+		return err
+	}
+
+	for i, cp := range caps {
+
+		r, w, err := os.Pipe()
+
+		if err == nil {
+			cp.orig, cp.fd, cp.w, cp.done = *cp.file, (*cp.file).Fd(), w, make(chan struct{})
+			err = captureFD(cp.fd, w, &cp.saved)
+		}
+
+		if err != nil {
+			if w != nil {
+				r.Close()
+				w.Close()
+			}
+			for _, prev := range caps[:i] {
+				release(prev)
+			}
+			return nil, err
+		}
+
+		// With the descriptor redirected, the pipe is written through
+		// the descriptor itself; otherwise through the variable.
+
+		if cp.saved != nil {
+			w.Close()
+		} else {
+			*cp.file = w
+		}
+
+		go func(cp *capture, r *os.File) {
+			defer close(cp.done)
+			defer r.Close()
+			lw := NewLineWriter(this.lineLogger(cp.c, cp.tag))
+			if _, err := io.Copy(lw, r); err != nil {
+				reportError(ErrorDecorator(err))
+			}
+			lw.Close()
+		}(cp, r)
+	}
+
+	// Errors reported through a standard logger that writes to the
+	// captured standard error would be fed back into the error channel.
+
+	var prevLogger *log.Logger
+	var swapped bool
+
+	if log.Writer() == io.Writer(caps[1].orig) {
+		prevLogger = setErrorLogger(log.New(newStdioFile(caps[1].orig), log.Prefix(), log.Flags()))
+		swapped = true
+	}
+
+	var once sync.Once
+
+	return func() (err error) {
+		once.Do(func() {
+			for _, cp := range caps {
+				if e := release(cp); e != nil && err == nil {
+					err = e
+				}
+			}
+			if swapped {
+				setErrorLogger(prevLogger)
+			}
+		})
+		return err
+	}, nil
+}
+
+// captureFD redirects descriptor fd to w, saving a duplicate of the
+// original in saved. If the platform cannot redirect descriptors, it
+// leaves saved nil and returns no error.
+func captureFD(fd uintptr, w *os.File, saved **os.File) (err error) {
+
+	nfd, err := dupFD(int(fd))
+
+	if err == errNoRedirect {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	orig := os.NewFile(uintptr(nfd), fmt.Sprintf(`fd%d`, fd))
+
+	stdio.Lock()
+	defer stdio.Unlock()
+
+	if err = redirectFD(int(w.Fd()), int(fd)); err != nil {
+		orig.Close()
+		return err
+	}
+
+	stdio.orig[fd], *saved = orig, orig
+
+	return nil
+}
+
+// AttachCmd sends the standard output and standard error of a command to
+// the given channels line by line, each line preceded by tag. It must be
+// called before the command is started. The returned function logs any
+// final line that lacks a line ending and should be called after the
+// command's Wait returns.
+func (this *MultiLoggerWriter) AttachCmd(cmd *exec.Cmd, tag string, stdout, stderr LogChannel) (flush func()) {
+
+	ow := NewLineWriter(this.lineLogger(stdout, tag))
+	ew := NewLineWriter(this.lineLogger(stderr, tag))
+
+	cmd.Stdout, cmd.Stderr = ow, ew
+
+	return func() {
+		ow.Close()
+		ew.Close()
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`errors`
+	`log`
+	`strings`
+	`testing`
+	`time`
+)
+
+func TestLineWriter(t *testing.T) {
+
+	var lines []string
+
+	lw := NewLineWriter(func(line string) { lines = append(lines, line) })
+
+	for _, s := range []string{"one\r\ntw", "o\n", "three\nfour"} {
+		if n, err := lw.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf(`Write(%q) = %d, %v`, s, n, err)
+		}
+	}
+
+	if got := strings.Join(lines, `|`); got != `one|two|three` {
+		t.Errorf(`got %q before Close`, got)
+	}
+
+	lw.Close()
+
+	if got := strings.Join(lines, `|`); got != `one|two|three|four` {
+		t.Errorf(`got %q after Close`, got)
+	}
+}
+
+func TestLineWriterReentrant(t *testing.T) {
+
+	var (
+		lw *LineWriter
+		lines []string
+	)
+
+	lw = NewLineWriter(func(line string) {
+		if lines = append(lines, line); line == `outer` {
+			lw.Write([]byte("inner\n"))
+		}
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		lw.Write([]byte("outer\n"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal(`line function writing to its own LineWriter deadlocked`)
+	}
+
+	if got := strings.Join(lines, `|`); got != `outer|inner` {
+		t.Errorf(`got %q`, got)
+	}
+}
+
+func TestRedirectStdLog(t *testing.T) {
+
+	prevOut := new(lockedBuffer)
+	out, prefix, flags := log.Writer(), log.Prefix(), log.Flags()
+
+	log.SetOutput(prevOut)
+	log.SetPrefix(`app: `)
+	log.SetFlags(log.Lmsgprefix)
+
+	defer func() {
+		log.SetOutput(out)
+		log.SetPrefix(prefix)
+		log.SetFlags(flags)
+	}()
+
+	bb := new(lockedBuffer)
+
+	mlw := new(MultiLoggerWriter).
+		Defaults().
+		EnableConsole(false).
+		EnableSyslog(false).
+		LogDir(t.TempDir()).
+		SystemLink(``).
+		AddWriter(SystemChannel, bb).
+		Init()
+
+	defer mlw.Close()
+
+	restore := mlw.RedirectStdLog(SystemChannel)
+
+	log.Printf(`from the standard logger %d`, 1)
+
+	// Errors MultiLoggerWriter cannot return go to the previous output,
+	// not into the redirected logger and back to the channel.
+
+	reportError(errors.New(`sink failed`))
+
+	restore()
+
+	log.Print(`after restore`)
+
+	got := bb.String()
+
+	if !strings.HasPrefix(got, `system `) || !strings.Contains(got, "from the standard logger 1\n") {
+		t.Errorf(`channel got %q`, got)
+	}
+
+	if strings.Count(got, "\n") != 1 || strings.Contains(got, `app: `) {
+		t.Errorf(`channel got more than the redirected line: %q`, got)
+	}
+
+	if got := prevOut.String(); !strings.Contains(got, `app: sink failed`) ||
+		!strings.HasSuffix(got, "app: after restore\n") || strings.Contains(got, `standard logger`) {
+		t.Errorf(`previous output got %q`, got)
+	}
+
+	if log.Writer() != prevOut || log.Prefix() != `app: ` || log.Flags() != log.Lmsgprefix {
+		t.Errorf(`restore left output %T, prefix %q, flags %d`, log.Writer(), log.Prefix(), log.Flags())
+	}
+}
+
+// reportingWriter reports an error on every write, as LogFile does when
+// it cannot roll over, and then succeeds.
+type reportingWriter struct{}
+
+func (this reportingWriter) Write(b []byte) (int, error) {
+	reportError(errors.New(`rollover failed`))
+	return len(b), nil
+}
+
+func TestRedirectStdLogSinkError(t *testing.T) {
+
+	prevOut := new(lockedBuffer)
+	out := log.Writer()
+	log.SetOutput(prevOut)
+
+	bb := new(lockedBuffer)
+
+	mlw := new(MultiLoggerWriter).
+		Defaults().
+		EnableConsole(false).
+		EnableSyslog(false).
+		LogDir(t.TempDir()).
+		SystemLink(``).
+		AddWriter(SystemChannel, reportingWriter{}).
+		AddWriter(SystemChannel, bb).
+		Init()
+
+	defer mlw.Close()
+
+	restore := mlw.RedirectStdLog(SystemChannel)
+
+	// The sink reports its error while the standard logger's lock is
+	// held; that must not re-enter the standard logger. If it does, the
+	// lock is never released, so the logger is restored only on success.
+
+	done := make(chan struct{})
+
+	go func() {
+		log.Print(`through a reporting sink`)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal(`logging through a sink that reports an error deadlocked`)
+	}
+
+	restore()
+	log.SetOutput(out)
+
+	if !strings.Contains(bb.String(), `through a reporting sink`) {
+		t.Errorf(`channel got %q`, bb.String())
+	}
+
+	if !strings.Contains(prevOut.String(), `rollover failed`) {
+		t.Errorf(`previous output got %q`, prevOut.String())
+	}
+}
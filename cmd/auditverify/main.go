@@ -0,0 +1,139 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command auditverify checks the hash chain and checkpoint signatures of
+// audit logs written by a MultiLoggerWriter audit channel. It reports the
+// first broken or missing entry in each file and exits 1 if any file
+// fails verification.
+//
+// Files are verified in the order given, each continuing from the last
+// record of the one before, so rotated files should be listed oldest
+// first. The first file must start from the genesis record or from the
+// -anchor record, written as seq:hash. A file that starts later without
+// an anchor may have had records removed from its start; it is reported
+// as UNANCHORED and, unless another file failed, auditverify exits 3.
+//
+// Usage:
+//
+//	auditverify [-key file] [-pubkey file] [-anchor seq:hash] log...
+package main
+
+import (
+	`crypto/ed25519`
+	`flag`
+	`fmt`
+	`os`
+	`github.com/jscherff/goutil`
+)
+
+func main() {
+
+	var (
+		keyFile = flag.String(`key`, ``, `file containing the hex-encoded HMAC key`)
+		pubFile = flag.String(`pubkey`, ``, `file containing the hex-encoded Ed25519 public key`)
+		anchorFlag = flag.String(`anchor`, ``, `seq:hash of the record the first log continues from`)
+		key []byte
+		pub ed25519.PublicKey
+		anchor *goutil.AuditAnchor
+		err error
+	)
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, `usage: auditverify [-key file] [-pubkey file] [-anchor seq:hash] log...`)
+		os.Exit(2)
+	}
+
+	if *anchorFlag != `` {
+		if anchor, err = goutil.ParseAuditAnchor(*anchorFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if *keyFile != `` {
+		if key, err = goutil.ReadAuditKey(*keyFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if *pubFile != `` {
+
+		b, err := goutil.ReadAuditKey(*pubFile)
+
+		if err == nil && len(b) != ed25519.PublicKeySize {
+			err = fmt.Errorf(`public key in %q has invalid length %d`, *pubFile, len(b))
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		pub = ed25519.PublicKey(b)
+	}
+
+	var failed, unanchored bool
+
+	for _, fn := range flag.Args() {
+
+		rpt, err := verify(fn, key, pub, anchor)
+
+		switch {
+		case err != nil:
+			fmt.Printf("%s: FAIL: %v\n", fn, err)
+			failed, anchor = true, nil
+		case rpt.Unanchored:
+			fmt.Printf("%s: UNANCHORED: starts at seq %d; earlier records cannot be checked\n", fn, rpt.FirstSeq)
+			unanchored = true
+		}
+
+		if err == nil && rpt.LastSeq > 0 {
+			anchor = &goutil.AuditAnchor{Seq: rpt.LastSeq, Hash: rpt.LastHash}
+		}
+	}
+
+	switch {
+	case failed:
+		os.Exit(1)
+	case unanchored:
+		os.Exit(3)
+	}
+}
+
+// verify checks a single audit log file, continuing from anchor if it is
+// not nil, and prints a summary if the chain is intact.
+func verify(fn string, key []byte, pub ed25519.PublicKey, anchor *goutil.AuditAnchor) (rpt *goutil.AuditReport, err error) {
+
+	fh, err := os.Open(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	if rpt, err = goutil.VerifyAuditLogFrom(fh, key, pub, anchor); err != nil {
+		return nil, err
+	}
+
+	if !rpt.Unanchored {
+		fmt.Printf("%s: OK: %d records, %d checkpoints, seq %d-%d\n",
+			fn, rpt.Records, rpt.Checkpoints, rpt.FirstSeq, rpt.LastSeq)
+	}
+
+	return rpt, nil
+}
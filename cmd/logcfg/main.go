@@ -0,0 +1,152 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command logcfg works with MultiLoggerWriter configuration files.
+//
+// Usage:
+//
+//	logcfg default           print the configuration set by Defaults
+//	logcfg validate file...  check files and report errors by line and column
+//	logcfg effective file    print Defaults merged with the file
+//	logcfg schema            print a JSON Schema for the configuration
+//
+// Files are validated as written. Their includes and, if the environment
+// variable GOUTIL_LOG_ENV is set, their overlay files are then loaded to
+// check that they resolve; "effective" prints the merged result.
+package main
+
+import (
+	`fmt`
+	`os`
+	`github.com/jscherff/goutil`
+)
+
+const usage = `usage: logcfg default | validate file... | effective file | schema`
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch cmd, args := os.Args[1], os.Args[2:]; {
+	case cmd == `default` && len(args) == 0:
+		err = printDefault()
+	case cmd == `validate` && len(args) > 0:
+		err = validate(args)
+	case cmd == `effective` && len(args) == 1:
+		err = printEffective(args[0])
+	case cmd == `schema` && len(args) == 0:
+		err = printSchema()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// printDefault prints the configuration produced by Defaults.
+func printDefault() (err error) {
+
+	b, err := new(goutil.MultiLoggerWriter).Defaults().GetConfig()
+
+	if err == nil {
+		fmt.Println(string(b))
+	}
+
+	return err
+}
+
+// printEffective prints the defaults overlaid with a configuration file.
+func printEffective(fn string) (err error) {
+
+	mlw := new(goutil.MultiLoggerWriter).Defaults()
+
+	if err = mlw.LoadConfig(fn); err != nil {
+		return err
+	}
+
+	b, err := mlw.GetConfig()
+
+	if err == nil {
+		fmt.Println(string(b))
+	}
+
+	return err
+}
+
+// printSchema prints the JSON Schema for configuration files.
+func printSchema() (err error) {
+
+	b, err := goutil.ConfigSchema()
+
+	if err == nil {
+		fmt.Println(string(b))
+	}
+
+	return err
+}
+
+// validate checks each file and prints its errors as file:line:col.
+func validate(files []string) (err error) {
+
+	var failed int
+
+	for _, fn := range files {
+
+		fh, err := os.Open(fn)
+
+		if err != nil {
+			return err
+		}
+
+		errs, err := goutil.ValidateConfig(fh)
+		fh.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, ce := range errs {
+			fmt.Printf("%s:%v\n", fn, ce)
+		}
+
+		if len(errs) == 0 {
+			if err := new(goutil.MultiLoggerWriter).Defaults().LoadConfig(fn); err != nil {
+				fmt.Println(err)
+				failed++
+				continue
+			}
+		}
+
+		if len(errs) > 0 {
+			failed++
+		} else {
+			fmt.Printf("%s: OK\n", fn)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf(`%d of %d files failed validation`, failed, len(files))
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	`encoding/json`
+	`io/ioutil`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+	`github.com/jscherff/goutil`
+)
+
+// captureStdout returns what fn prints to standard output.
+func captureStdout(t *testing.T, fn func()) string {
+
+	pr, pw, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = pw
+
+	out := make(chan string)
+
+	go func() {
+		b, _ := ioutil.ReadAll(pr)
+		out <- string(b)
+	}()
+
+	fn()
+
+	os.Stdout = stdout
+	pw.Close()
+
+	return <-out
+}
+
+func TestValidatePositions(t *testing.T) {
+
+	cases := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{
+			`structure`,
+			"{\n\t\"Options\": {\n\t\t\"Bogus\": 1,\n\t\t\"Console\": {\"System\": \"yes\"}\n\t},\n\t\"Config\": {\n\t\t\"AppName\": 5\n\t}\n}\n",
+			[]string{
+				`3:3: Options.Bogus: unknown field`,
+				`4:25: Options.Console.System: expected boolean, found string`,
+				`7:14: Config.AppName: expected string, found number`,
+			},
+		},
+		{
+			`rules`,
+			"{\n\t\"Options\": {\n\t\t\"LoggerFlags\": {\"LongFile\": true, \"ShortFile\": true}\n\t}\n}\n",
+			[]string{
+				`3:18: Options.LoggerFlags: LongFile and ShortFile are mutually exclusive`,
+			},
+		},
+		{
+			`valid`,
+			"{\n\t\"Config\": {\n\t\t\"AppName\": \"app\"\n\t}\n}\n",
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+
+		errs, err := goutil.ValidateConfig(strings.NewReader(tc.json))
+
+		if err != nil {
+			t.Fatalf(`%s: %v`, tc.name, err)
+		}
+
+		var got []string
+
+		for _, ce := range errs {
+			got = append(got, ce.Error())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+
+	errs, err := goutil.ValidateConfig(strings.NewReader("{\n\t\"Config\": {\n\t\t\"AppName\": \"x\",\n\t}\n}\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The decoder's message varies between Go releases; the location
+	// must still be that of the stray comma.
+
+	if len(errs) != 1 || errs[0].Line != 3 || errs[0].Column < 17 {
+		t.Errorf(`syntax error reported as %v`, errs)
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, `good.json`), filepath.Join(dir, `bad.json`)
+
+	if err := ioutil.WriteFile(good, []byte(`{"Config": {"AppName": "app"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(bad, []byte("{\n  \"Config\": {\"AppName\": 5}\n}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	out := captureStdout(t, func() { err = validate([]string{good, bad}) })
+
+	want := good + ": OK\n" + bad + ":2:25: Config.AppName: expected string, found number\n"
+
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	if err == nil || err.Error() != `1 of 2 files failed validation` {
+		t.Errorf(`validate returned %v`, err)
+	}
+}
+
+func TestPrintSchema(t *testing.T) {
+
+	var err error
+	out := captureStdout(t, func() { err = printSchema() })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Type string `json:"type"`
+		AdditionalProperties bool `json:"additionalProperties"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+
+	if err = json.Unmarshal([]byte(out), &schema); err != nil {
+		t.Fatalf(`schema is not JSON: %v`, err)
+	}
+
+	if schema.Type != `object` || schema.AdditionalProperties {
+		t.Errorf(`root is type %q with additionalProperties %t`, schema.Type, schema.AdditionalProperties)
+	}
+
+	for _, k := range []string{`Options`, `Config`, `Include`} {
+		if _, ok := schema.Properties[k]; !ok {
+			t.Errorf(`schema does not declare %s`, k)
+		}
+	}
+}
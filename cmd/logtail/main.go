@@ -0,0 +1,494 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command logtail reads and follows the files written by a
+// MultiLoggerWriter. Files of several channels, including rotated and
+// gzip-compressed backups, are merged by timestamp and filtered by
+// channel, level, time range, request ID or regular expression. Both the
+// text format and JSON formats such as audit records are understood.
+//
+// Usage:
+//
+//	logtail [-config file] [-dir dir] [-channel list] [flags] [file...]
+//
+// With -config, the files of each channel are located from the LogDir and
+// LogFiles settings, and text timestamps are read in the zone the
+// configuration writes them in. The application resolves a relative
+// LogDir against its own directory, which logtail cannot know, so such a
+// LogDir must be given with -dir. Without -config, the named files are
+// read, and each file's channel is taken from its base name.
+package main
+
+import (
+	`bufio`
+	`compress/gzip`
+	`flag`
+	`fmt`
+	`io`
+	`os`
+	`path/filepath`
+	`regexp`
+	`sort`
+	`strings`
+	`time`
+	`github.com/jscherff/goutil`
+)
+
+func main() {
+
+	var (
+		config = flag.String(`config`, ``, `MultiLoggerWriter configuration file`)
+		dir = flag.String(`dir`, ``, `log directory, overriding the configuration`)
+		channels = flag.String(`channel`, `system,access,error`, `comma-separated channels to read`)
+		follow = flag.Bool(`f`, false, `follow files as they grow and rotate`)
+		last = flag.Int(`n`, 0, `show only the last n matching entries before following`)
+		level = flag.String(`level`, ``, `show only entries at this level (error, warn, info, debug, fatal)`)
+		since = flag.String(`since`, ``, `show entries at or after this time or duration ago`)
+		until = flag.String(`until`, ``, `show entries at or before this time`)
+		reqID = flag.String(`request-id`, ``, `show entries with this request ID`)
+		grep = flag.String(`grep`, ``, `show entries matching this regular expression`)
+		interval = flag.Duration(`interval`, 500 * time.Millisecond, `polling interval when following`)
+		err error
+	)
+
+	flag.Parse()
+
+	flt := &filter{requestID: *reqID}
+
+	if flt.level, err = parseLevel(*level); err == nil {
+		flt.since, err = parseTime(*since)
+	}
+
+	if err == nil {
+		flt.until, err = parseTime(*until)
+	}
+
+	if err == nil && *grep != `` {
+		flt.re, err = regexp.Compile(*grep)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+
+	var srcs []*source
+
+	if *config != `` {
+		srcs, err = configSources(*config, *dir, strings.Split(*channels, `,`))
+	} else if flag.NArg() > 0 {
+		srcs = fileSources(flag.Args())
+	} else {
+		err = fmt.Errorf(`either -config or one or more files is required`)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+
+	prefix := len(srcs) > 1
+
+	var entries []*entry
+
+	for _, src := range srcs {
+		es, err := src.readAll()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		entries = append(entries, es...)
+	}
+
+	entries = selectEntries(entries, flt)
+
+	if *last > 0 && len(entries) > *last {
+		entries = entries[len(entries) - *last:]
+	}
+
+	printEntries(entries, prefix)
+
+	if !*follow {
+		return
+	}
+
+	for range time.Tick(*interval) {
+
+		entries = nil
+
+		for _, src := range srcs {
+			es, err := src.poll()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			entries = append(entries, es...)
+		}
+
+		printEntries(selectEntries(entries, flt), prefix)
+	}
+}
+
+// fatal reports an error and exits.
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, `logtail:`, err)
+	os.Exit(1)
+}
+
+// selectEntries filters entries and sorts them by timestamp.
+func selectEntries(entries []*entry, flt *filter) (sel []*entry) {
+
+	for _, e := range entries {
+		if flt.match(e) {
+			sel = append(sel, e)
+		}
+	}
+
+	sort.SliceStable(sel, func(i, j int) bool {
+		return sel[i].time.Before(sel[j].time)
+	})
+
+	return sel
+}
+
+// printEntries writes entries to stdout, prefixed with their channel
+// when more than one source is being read.
+func printEntries(entries []*entry, prefix bool) {
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	for _, e := range entries {
+		if prefix {
+			fmt.Fprintf(w, "[%s] %s\n", e.channel, e.line)
+		} else {
+			fmt.Fprintln(w, e.line)
+		}
+	}
+}
+
+// source is the set of files belonging to one channel.
+type source struct {
+	lineFormat
+	pattern string
+	match *regexp.Regexp
+	fh *os.File
+	fi os.FileInfo
+	rd *bufio.Reader
+	partial string
+	lastTime time.Time
+}
+
+// configSources locates the files of each channel from a configuration.
+func configSources(cf, dir string, channels []string) (srcs []*source, err error) {
+
+	mlw := new(goutil.MultiLoggerWriter).Defaults()
+
+	if err = mlw.LoadConfig(cf); err != nil {
+		return nil, err
+	}
+
+	// MultiLoggerWriter.Init resolves a relative LogDir against the
+	// application's directory, which need not be logtail's.
+
+	if dir == `` {
+
+		if dir = mlw.Config.LogDir; dir == `` {
+			dir = `log`
+		}
+
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf(`%s: LogDir %q is relative to the application's directory; use -dir`, cf, dir)
+		}
+	}
+
+	type channel struct {
+		name string
+		tag string
+		prefix goutil.PrefixFormat
+	}
+
+	cfs := map[string]channel{
+		`system`: {mlw.Config.LogFiles.System, mlw.Config.LogTags.System, mlw.Config.Prefix.System},
+		`access`: {mlw.Config.LogFiles.Access, mlw.Config.LogTags.Access, mlw.Config.Prefix.Access},
+		`error`: {mlw.Config.LogFiles.Error, mlw.Config.LogTags.Error, mlw.Config.Prefix.Error},
+	}
+
+	for _, c := range channels {
+
+		cc, ok := cfs[strings.TrimSpace(c)]
+
+		if !ok {
+			return nil, fmt.Errorf(`unknown channel %q`, c)
+		}
+
+		// Text timestamps are in the prefix format's zone if it has one,
+		// otherwise in UTC or local time as the logger flags select.
+
+		loc := time.Local
+
+		switch tz := cc.prefix.TimeZone; {
+		case tz != `` && tz != `Local`:
+			if loc, err = time.LoadLocation(tz); err != nil {
+				return nil, err
+			}
+		case mlw.Options.LoggerFlags.UTC:
+			loc = time.UTC
+		}
+
+		// Any host's files are shown, as the log directory may be shared.
+
+		data := goutil.LogFileData{AppName: mlw.Config.AppName}
+		match, err := goutil.RetentionRegexp(dir, cc.name, data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		srcs = append(srcs, &source{
+			lineFormat: lineFormat{
+				channel: strings.TrimSpace(c),
+				tag: strings.TrimSpace(cc.tag),
+				loc: loc,
+			},
+			pattern: goutil.RetentionPattern(dir, cc.name),
+			match: match,
+		})
+	}
+
+	return srcs, nil
+}
+
+// fileSources treats each argument as a file or glob pattern. The channel
+// name, taken from the file name, is assumed to be the tag, as it is by
+// default.
+func fileSources(args []string) (srcs []*source) {
+
+	for _, arg := range args {
+		base := filepath.Base(arg)
+		channel := strings.TrimSuffix(base, filepath.Ext(base))
+		srcs = append(srcs, &source{
+			lineFormat: lineFormat{channel: channel, tag: channel, loc: time.Local},
+			pattern: arg,
+		})
+	}
+
+	return srcs
+}
+
+// files returns the regular files matching the source pattern, oldest
+// first. Symlinks to the current file are skipped.
+func (this *source) files() (paths []string, infos []os.FileInfo) {
+
+	matches, _ := filepath.Glob(this.pattern)
+
+	type file struct {
+		path string
+		info os.FileInfo
+	}
+
+	var files []file
+
+	for _, m := range matches {
+		if this.match != nil && !this.match.MatchString(m) {
+			continue
+		}
+		if fi, err := os.Lstat(m); err == nil && fi.Mode().IsRegular() {
+			files = append(files, file{m, fi})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	for _, f := range files {
+		paths = append(paths, f.path)
+		infos = append(infos, f.info)
+	}
+
+	return paths, infos
+}
+
+// current returns the newest uncompressed file, which is the one being
+// written.
+func (this *source) current() (path string, fi os.FileInfo) {
+
+	paths, infos := this.files()
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		if !strings.HasSuffix(paths[i], `.gz`) {
+			return paths[i], infos[i]
+		}
+	}
+
+	return ``, nil
+}
+
+// readAll reads every file of the source, oldest first, and leaves the
+// current file open for following.
+func (this *source) readAll() (entries []*entry, err error) {
+
+	cur, _ := this.current()
+	paths, _ := this.files()
+
+	for _, p := range paths {
+
+		if p == cur {
+			continue
+		}
+
+		es, err := this.readFile(p)
+
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, es...)
+	}
+
+	if cur == `` {
+		return entries, nil
+	}
+
+	if err = this.open(cur); err != nil {
+		return entries, err
+	}
+
+	es, err := this.poll()
+
+	return append(entries, es...), err
+}
+
+// readFile reads a complete file, decompressing it if necessary.
+func (this *source) readFile(fn string) (entries []*entry, err error) {
+
+	fh, err := os.Open(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	var r io.Reader = fh
+
+	if strings.HasSuffix(fn, `.gz`) {
+
+		zr, err := gzip.NewReader(fh)
+
+		if err != nil {
+			return nil, fmt.Errorf(`%s: %v`, fn, err)
+		}
+
+		defer zr.Close()
+		r = zr
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024 * 1024)
+
+	for sc.Scan() {
+		entries = append(entries, this.parse(sc.Text()))
+	}
+
+	return entries, sc.Err()
+}
+
+// open opens the current file for following.
+func (this *source) open(fn string) (err error) {
+
+	if this.fh != nil {
+		this.fh.Close()
+	}
+
+	if this.fh, err = os.Open(fn); err != nil {
+		this.fh = nil
+		return err
+	}
+
+	this.fi, _ = this.fh.Stat()
+	this.rd = bufio.NewReader(this.fh)
+	this.partial = ``
+
+	return nil
+}
+
+// poll returns the complete lines appended since the last call. If the
+// file has been rotated or truncated, the rest of the old file is read
+// and the new file is read from the beginning.
+func (this *source) poll() (entries []*entry, err error) {
+
+	if this.fh != nil {
+		entries, err = this.readLines()
+	}
+
+	cur, fi := this.current()
+
+	if cur == `` {
+		return entries, err
+	}
+
+	switch {
+	case this.fh == nil || !os.SameFile(this.fi, fi):
+	case fi.Size() < this.offset():
+	default:
+		return entries, err
+	}
+
+	if err = this.open(cur); err != nil {
+		return entries, err
+	}
+
+	es, err := this.readLines()
+
+	return append(entries, es...), err
+}
+
+// offset returns the current read position in the open file.
+func (this *source) offset() int64 {
+	off, _ := this.fh.Seek(0, io.SeekCurrent)
+	return off - int64(this.rd.Buffered())
+}
+
+// readLines reads complete lines from the open file, holding back any
+// partial line until it is finished.
+func (this *source) readLines() (entries []*entry, err error) {
+
+	for {
+
+		s, err := this.rd.ReadString('\n')
+		this.partial += s
+
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, this.parse(strings.TrimRight(this.partial, "\r\n")))
+		this.partial = ``
+	}
+}
+
+// parse parses a line, giving lines without a timestamp the time of the
+// preceding line so that they stay in place when merged.
+func (this *source) parse(line string) *entry {
+
+	e := parse(line, this.lineFormat)
+
+	if e.time.IsZero() {
+		e.time = this.lastTime
+	} else {
+		this.lastTime = e.time
+	}
+
+	return e
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	`fmt`
+	`io/ioutil`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+// writeConfig writes a configuration file with the given LogDir and
+// returns its path.
+func writeConfig(t *testing.T, dir, logDir string) string {
+
+	cf := filepath.Join(dir, `config.json`)
+
+	s := fmt.Sprintf(`{
+		"Options": {"LoggerFlags": {"UTC": true}},
+		"Config": {
+			"AppName": "app",
+			"LogDir": %q,
+			"LogFiles": {"System": "system.log", "Access": "access.log", "Error": "error.log"},
+			"LogTags": {"System": "sys", "Access": "acc", "Error": "err"},
+			"Prefix": {"Access": {"TimeZone": "Europe/Paris"}}
+		}
+	}`, logDir)
+
+	if err := ioutil.WriteFile(cf, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cf
+}
+
+func TestConfigSourcesLogDir(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// A relative LogDir depends on the application's directory.
+
+	for _, logDir := range []string{``, `log`, `var/log`} {
+		if _, err := configSources(writeConfig(t, dir, logDir), ``, []string{`system`}); err == nil {
+			t.Errorf(`LogDir %q accepted`, logDir)
+		}
+	}
+
+	if _, err := configSources(writeConfig(t, dir, `log`), `log`, []string{`system`}); err != nil {
+		t.Errorf(`-dir with relative LogDir: %v`, err)
+	}
+
+	abs := filepath.Join(dir, `log`)
+	srcs, err := configSources(writeConfig(t, dir, abs), ``, []string{`system`, `access`, `error`})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(srcs[0].pattern, abs) {
+		t.Errorf(`pattern %q not in %q`, srcs[0].pattern, abs)
+	}
+
+	// LUTC applies unless the prefix format names a zone.
+
+	for i, want := range []string{`UTC`, `Europe/Paris`, `UTC`} {
+		if got := srcs[i].loc.String(); got != want {
+			t.Errorf(`%s: zone %s, want %s`, srcs[i].channel, got, want)
+		}
+	}
+
+	if srcs[2].tag != `err` {
+		t.Errorf(`tag %q, want "err"`, srcs[2].tag)
+	}
+
+	if _, err = configSources(writeConfig(t, dir, abs), ``, []string{`debug`}); err == nil {
+		t.Error(`unknown channel accepted`)
+	}
+}
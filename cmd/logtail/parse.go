@@ -0,0 +1,208 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	`encoding/json`
+	`fmt`
+	`regexp`
+	`strings`
+	`time`
+	`github.com/jscherff/goutil`
+)
+
+// entry is one parsed log line.
+type entry struct {
+	channel string
+	line string
+	time time.Time
+	level string
+	fields map[string]string
+}
+
+var (
+	fieldRe = regexp.MustCompile(`(\w[\w.-]*)=("(?:[^"\\]|\\.)*"|\S+)`)
+	rfc3339Re = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})`)
+	stdTimeRe = regexp.MustCompile(`\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)?`)
+)
+
+// lineFormat describes how the text lines of a channel are written: the
+// tag that begins them and the zone of their timestamps.
+type lineFormat struct {
+	channel string
+	tag string
+	loc *time.Location
+}
+
+// parse extracts the timestamp, level and key=value fields from a line in
+// either the text format written by log.Logger and PrefixWriter or a JSON
+// format such as audit records.
+func parse(line string, lf lineFormat) *entry {
+
+	e := &entry{channel: lf.channel, line: line, fields: make(map[string]string)}
+
+	if strings.HasPrefix(strings.TrimSpace(line), `{`) {
+
+		var obj map[string]interface{}
+
+		if err := json.Unmarshal([]byte(line), &obj); err == nil {
+
+			for k, v := range obj {
+				e.fields[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+
+			for _, k := range []string{`time`, `ts`, `timestamp`} {
+				if t, err := time.Parse(time.RFC3339Nano, e.fields[k]); err == nil {
+					e.time = t
+					break
+				}
+			}
+
+			e.level, _ = goutil.ParseLevel(e.fields[`level`])
+			msg := e.fields[`msg`]
+
+			if e.time.IsZero() || e.level == `` {
+				inner := parse(msg, lf)
+				if e.time.IsZero() {
+					e.time = inner.time
+				}
+				if e.level == `` {
+					e.level = inner.level
+				}
+			}
+
+			return e
+		}
+	}
+
+	if s := rfc3339Re.FindString(line); s != `` {
+		e.time, _ = time.Parse(time.RFC3339Nano, s)
+	} else if s := stdTimeRe.FindString(line); s != `` {
+		e.time, _ = time.ParseInLocation(`2006/01/02 15:04:05.999999`, s, lf.loc)
+	}
+
+	// The level is looked for in the message only, as the tag, such as
+	// the error channel's "error", is itself a level word.
+
+	msg := line
+
+	if lf.tag != `` && strings.HasPrefix(msg, lf.tag + ` `) {
+		msg = msg[len(lf.tag):]
+	}
+
+	msg = strings.TrimSpace(msg)
+
+	for _, re := range []*regexp.Regexp{rfc3339Re, stdTimeRe} {
+		if loc := re.FindStringIndex(msg); loc != nil && loc[0] == 0 {
+			msg = msg[loc[1]:]
+		}
+	}
+
+	e.level = goutil.FindLevel([]byte(msg))
+
+	for _, m := range fieldRe.FindAllStringSubmatch(line, -1) {
+		e.fields[strings.ToLower(m[1])] = strings.Trim(m[2], `"`)
+	}
+
+	return e
+}
+
+// filter selects entries.
+type filter struct {
+	level string
+	since time.Time
+	until time.Time
+	requestID string
+	re *regexp.Regexp
+}
+
+// requestKeys are the field names treated as request IDs.
+var requestKeys = []string{`request_id`, `requestid`, `req_id`, `reqid`, `rid`}
+
+// match reports whether an entry passes the filter. Entries without a
+// timestamp pass the time range.
+func (this *filter) match(e *entry) bool {
+
+	if this.level != `` && e.level != this.level {
+		return false
+	}
+
+	if !e.time.IsZero() {
+		if !this.since.IsZero() && e.time.Before(this.since) {
+			return false
+		}
+		if !this.until.IsZero() && e.time.After(this.until) {
+			return false
+		}
+	}
+
+	if this.requestID != `` {
+
+		var found bool
+
+		for _, k := range requestKeys {
+			if e.fields[k] == this.requestID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if this.re != nil && !this.re.MatchString(e.line) {
+		return false
+	}
+
+	return true
+}
+
+// parseLevel returns the canonical name of a level word, such as "warn"
+// for "WARNING", so that it compares equal to the levels of entries.
+func parseLevel(s string) (string, error) {
+
+	if s == `` {
+		return ``, nil
+	}
+
+	if level, ok := goutil.ParseLevel(s); ok {
+		return level, nil
+	}
+
+	return ``, fmt.Errorf(`invalid level %q`, s)
+}
+
+// parseTime accepts an RFC 3339 time, a date, or a duration meaning that
+// long before now.
+func parseTime(s string) (time.Time, error) {
+
+	if s == `` {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, `2006-01-02T15:04:05`, `2006-01-02 15:04:05`, `2006-01-02`} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(`invalid time %q`, s)
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	`testing`
+	`time`
+)
+
+func TestParseLevel(t *testing.T) {
+
+	lf := lineFormat{channel: `error`, tag: `error`, loc: time.UTC}
+
+	for line, want := range map[string]string{
+		`error 2026/01/02 03:04:05 connection reset`: ``,
+		`error 2026/01/02 03:04:05 WARNING: disk nearly full`: `warn`,
+		`error 2026-01-02T03:04:05Z panic: nil map`: `fatal`,
+		`error connection error`: `error`,
+		`errorlog 2026/01/02 03:04:05 error: retrying`: `error`,
+		`{"level":"debug","msg":"error 2026/01/02 03:04:05 x"}`: `debug`,
+		`{"msg":"error 2026/01/02 03:04:05 info: ready"}`: `info`,
+	} {
+		if e := parse(line, lf); e.level != want {
+			t.Errorf(`%s: level %q, want %q`, line, e.level, want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+
+	ny, err := time.LoadLocation(`America/New_York`)
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, tc := range []struct {
+		line string
+		loc *time.Location
+		want time.Time
+	}{
+		{`system 2026/01/02 03:04:05 started`, time.UTC, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{`system 2026/01/02 03:04:05.250 started`, ny, time.Date(2026, 1, 2, 8, 4, 5, 250e6, time.UTC)},
+		{`system 2026-01-02T03:04:05+01:00 started`, ny, time.Date(2026, 1, 2, 2, 4, 5, 0, time.UTC)},
+		{`{"time":"2026-01-02T03:04:05Z","msg":"started"}`, ny, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+	} {
+		e := parse(tc.line, lineFormat{channel: `system`, tag: `system`, loc: tc.loc})
+
+		if !e.time.Equal(tc.want) {
+			t.Errorf(`%s in %v: time %v, want %v`, tc.line, tc.loc, e.time, tc.want)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+
+	e := parse(`access 2026/01/02 03:04:05 GET /x request_id=abc msg="a b"`, lineFormat{tag: `access`, loc: time.UTC})
+
+	if e.fields[`request_id`] != `abc` || e.fields[`msg`] != `a b` {
+		t.Errorf(`fields %v`, e.fields)
+	}
+
+	if !(&filter{requestID: `abc`}).match(e) || (&filter{requestID: `xyz`}).match(e) {
+		t.Error(`request ID filter`)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`encoding/json`
+	`fmt`
+	`io`
+	`os`
+	`regexp`
+	`sort`
+	`strings`
+)
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold = "\x1b[1m"
+	ansiDim = "\x1b[2m"
+	ansiRed = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan = "\x1b[36m"
+
+	consoleTagWidth = 8
+	consoleTimeWidth = 19
+)
+
+var (
+	consoleTimeRe = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} )?(\d{2}:\d{2}:\d{2}(\.\d+)? )?`)
+	consoleFileRe = regexp.MustCompile(`^\S+\.go:\d+: `)
+	consoleFieldRe = regexp.MustCompile(`(\w[\w.-]*)=("(?:[^"\\]|\\.)*"|\S+)`)
+)
+
+// ConsoleWriter is an io.Writer that reformats log.Logger output for
+// people reading a terminal. Timestamps and tags are aligned, the tag is
+// coloured by channel, the message by level, and key=value or JSON object
+// messages are rendered as fields. Colour is used only when the output is
+// a terminal and the NO_COLOR environment variable is not set.
+type ConsoleWriter struct {
+	w io.Writer
+	channel LogChannel
+	tag string
+	color bool
+}
+
+// NewConsoleWriter returns a ConsoleWriter for the given channel that
+// writes to f. The tag is the channel's log prefix, which is stripped from
+// each line and redisplayed in its own column.
+func NewConsoleWriter(f *os.File, c LogChannel, tag string) (this *ConsoleWriter) {
+	return &ConsoleWriter{
+		w: newStdioFile(f),
+		channel: c,
+		tag: strings.TrimSpace(tag),
+		color: IsTerminal(f) && os.Getenv(`NO_COLOR`) == ``,
+	}
+}
+
+// SetColor overrides terminal detection.
+func (this *ConsoleWriter) SetColor(b bool) *ConsoleWriter {
+	this.color = b
+	return this
+}
+
+// IsTerminal reports whether f is a character device such as a terminal.
+func IsTerminal(f *os.File) bool {
+
+	fi, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Write reformats each line in b and writes the result to the underlying
+// writer. It returns len(b) on success so that callers see their own byte
+// count rather than that of the reformatted output.
+func (this *ConsoleWriter) Write(b []byte) (n int, err error) {
+
+	bb := new(bytes.Buffer)
+
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\r\n"), "\n") {
+		bb.WriteString(this.format(line))
+		bb.WriteByte('\n')
+	}
+
+	if _, err = this.w.Write(bb.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// format renders a single line.
+func (this *ConsoleWriter) format(line string) string {
+
+	line = strings.TrimPrefix(line, this.tag)
+	line = strings.TrimPrefix(line, ` `)
+
+	ts := strings.TrimSpace(consoleTimeRe.FindString(line))
+	line = line[len(consoleTimeRe.FindString(line)):]
+
+	file := consoleFileRe.FindString(line)
+	line = line[len(file):]
+
+	msg, fields := this.fields(line)
+
+	bb := new(bytes.Buffer)
+
+	if ts != `` {
+		this.paint(bb, ansiDim, fmt.Sprintf(`%-*s`, consoleTimeWidth, ts))
+		bb.WriteByte(' ')
+	}
+
+	this.paint(bb, ansiBold + this.channelColor(), fmt.Sprintf(`%-*s`, consoleTagWidth, strings.ToUpper(this.tag)))
+	bb.WriteByte(' ')
+
+	if file != `` {
+		this.paint(bb, ansiDim, strings.TrimSuffix(file, ` `))
+		bb.WriteByte(' ')
+	}
+
+	this.paint(bb, this.levelColor(msg), msg)
+
+	for _, kv := range fields {
+		bb.WriteByte(' ')
+		this.paint(bb, ansiCyan, kv[0])
+		bb.WriteByte('=')
+		bb.WriteString(kv[1])
+	}
+
+	return bb.String()
+}
+
+// fields splits a message into its text and any structured fields. A
+// message that is a JSON object is rendered entirely as fields; otherwise
+// trailing key=value pairs are separated from the text.
+func (this *ConsoleWriter) fields(msg string) (text string, kvs [][2]string) {
+
+	trimmed := strings.TrimSpace(msg)
+
+	if strings.HasPrefix(trimmed, `{`) {
+
+		var obj map[string]interface{}
+
+		if err := json.Unmarshal([]byte(trimmed), &obj); err == nil {
+
+			var keys []string
+
+			for k := range obj {
+				keys = append(keys, k)
+			}
+
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				if k == `msg` || k == `message` {
+					text = fmt.Sprintf(`%v`, obj[k])
+					continue
+				}
+				b, _ := json.Marshal(obj[k])
+				kvs = append(kvs, [2]string{k, string(b)})
+			}
+
+			return text, kvs
+		}
+	}
+
+	// Peel key=value pairs off the end of the message.
+
+	text = msg
+
+	for {
+		loc := consoleFieldRe.FindAllStringSubmatchIndex(text, -1)
+
+		if len(loc) == 0 {
+			break
+		}
+
+		last := loc[len(loc)-1]
+
+		if strings.TrimSpace(text[last[1]:]) != `` {
+			break
+		}
+
+		kvs = append([][2]string{{text[last[2]:last[3]], text[last[4]:last[5]]}}, kvs...)
+		text = strings.TrimRight(text[:last[0]], ` `)
+	}
+
+	return text, kvs
+}
+
+// paint writes s to bb wrapped in the given ANSI color if color is enabled.
+func (this *ConsoleWriter) paint(bb *bytes.Buffer, color, s string) {
+
+	if !this.color || color == `` {
+		bb.WriteString(s)
+		return
+	}
+
+	bb.WriteString(color)
+	bb.WriteString(s)
+	bb.WriteString(ansiReset)
+}
+
+// channelColor returns the color used for the channel's tag.
+func (this *ConsoleWriter) channelColor() string {
+	switch this.channel {
+	case AccessChannel:
+		return ansiGreen
+	case ErrorChannel:
+		return ansiRed
+	default:
+		return ansiBlue
+	}
+}
+
+// levelColor returns the color for the first level word found in the
+// message, or no color if there is none.
+func (this *ConsoleWriter) levelColor(msg string) string {
+
+	switch FindLevel([]byte(msg)) {
+	case `fatal`:
+		return ansiBold + ansiMagenta
+	case `error`:
+		return ansiRed
+	case `warn`:
+		return ansiYellow
+	case `debug`:
+		return ansiDim
+	default:
+		return ``
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+// openCharDevice opens /dev/null, a character device that IsTerminal
+// treats as a terminal, or skips the test.
+func openCharDevice(t *testing.T) *os.File {
+
+	t.Helper()
+
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if fi, err := f.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		f.Close()
+		t.Skipf(`%s is not a character device`, os.DevNull)
+	}
+
+	t.Cleanup(func() { f.Close() })
+
+	return f
+}
+
+func TestIsTerminal(t *testing.T) {
+
+	pr, pw, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pr.Close()
+	defer pw.Close()
+
+	fh, err := os.Create(filepath.Join(t.TempDir(), `test.log`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fh.Close()
+
+	if IsTerminal(pw) {
+		t.Error(`pipe reported as a terminal`)
+	}
+
+	if IsTerminal(fh) {
+		t.Error(`regular file reported as a terminal`)
+	}
+
+	if !IsTerminal(openCharDevice(t)) {
+		t.Error(`character device not reported as a terminal`)
+	}
+}
+
+func TestConsoleWriterColorDetection(t *testing.T) {
+
+	tty := openCharDevice(t)
+
+	pr, pw, err := os.Pipe()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pr.Close()
+	defer pw.Close()
+
+	cases := []struct {
+		name    string
+		f       *os.File
+		noColor string
+		want    bool
+	}{
+		{`terminal`, tty, ``, true},
+		{`terminal with NO_COLOR`, tty, `1`, false},
+		{`pipe`, pw, ``, false},
+		{`pipe with NO_COLOR`, pw, `1`, false},
+	}
+
+	for _, tc := range cases {
+
+		t.Setenv(`NO_COLOR`, tc.noColor)
+
+		if cw := NewConsoleWriter(tc.f, SystemChannel, `system`); cw.color != tc.want {
+			t.Errorf(`%s: color = %t, want %t`, tc.name, cw.color, tc.want)
+		}
+	}
+}
+
+func TestConsoleWriterOutput(t *testing.T) {
+
+	line := "error 2017/01/02 03:04:05 main.go:10: disk error path=/var/log\n"
+
+	for _, color := range []bool{true, false} {
+
+		bb := new(bytes.Buffer)
+		cw := NewConsoleWriter(os.Stderr, ErrorChannel, `error`).SetColor(color)
+		cw.w = bb
+
+		if n, err := cw.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf(`Write = %d, %v`, n, err)
+		}
+
+		got := bb.String()
+
+		if strings.Contains(got, "\x1b[") != color {
+			t.Errorf(`color %t: escape sequences in %q`, color, got)
+		}
+
+		if !color {
+			want := "2017/01/02 03:04:05 ERROR    main.go:10: disk error path=/var/log\n"
+			if got != want {
+				t.Errorf(`got %q, want %q`, got, want)
+			}
+		} else if !strings.Contains(got, ansiRed + `disk error`) {
+			t.Errorf(`error message not coloured red: %q`, got)
+		}
+	}
+}
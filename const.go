@@ -28,5 +28,17 @@ const (
 	FileModeDefault = 0640
 	DirModeDefault = 0750
 
+	LogFileDateLayout = `2006-01-02`
+
+	DiskFallbackConsole = `console`
+	DiskFallbackSyslog = `syslog`
+	DiskFallbackRing = `ring`
+
 	LoggerFlags = log.LstdFlags
+
+	ConfigIncludeKey = `Include`
+	ConfigEnvVar = `GOUTIL_LOG_ENV`
+
+	loggerTimeFlags = log.Ldate|log.Ltime|log.Lmicroseconds|log.LUTC
+	recordFileFlags = log.Lshortfile|log.Llongfile
 )
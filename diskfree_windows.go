@@ -0,0 +1,42 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`syscall`
+	`unsafe`
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL(`kernel32.dll`).NewProc(`GetDiskFreeSpaceExW`)
+
+// DiskFree returns the number of bytes available to the calling user on
+// the volume containing path.
+func DiskFree(path string) (free uint64, err error) {
+
+	p, err := syscall.UTF16PtrFromString(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	r, _, e := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)), 0, 0)
+
+	if r == 0 {
+		return 0, e
+	}
+
+	return free, nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`io`
+	`log`
+	`sync`
+	`time`
+)
+
+// SwitchWriter is an io.Writer that sends output to its primary writer
+// or, after Fallback is called, to a fallback writer until Restore.
+type SwitchWriter struct {
+	mu sync.RWMutex
+	primary io.Writer
+	fallback io.Writer
+}
+
+// NewSwitchWriter returns a SwitchWriter writing to primary.
+func NewSwitchWriter(primary io.Writer) (this *SwitchWriter) {
+	return &SwitchWriter{primary: primary}
+}
+
+// Write writes to the active writer.
+func (this *SwitchWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	if this.fallback != nil {
+		return this.fallback.Write(b)
+	}
+
+	return this.primary.Write(b)
+}
+
+// Fallback redirects output to w.
+func (this *SwitchWriter) Fallback(w io.Writer) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.fallback = w
+}
+
+// Restore redirects output back to the primary writer and returns the
+// fallback writer that was in use, if any. If the fallback is a
+// RingBuffer, its contents are first replayed to the primary writer;
+// writes wait until the replay is done so that they follow it.
+func (this *SwitchWriter) Restore() (w io.Writer, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	w, this.fallback = this.fallback, nil
+
+	if rb, ok := w.(*RingBuffer); ok {
+		_, err = rb.WriteTo(this.primary)
+	}
+
+	return w, err
+}
+
+// Primary returns the primary writer.
+func (this *SwitchWriter) Primary() io.Writer {
+	return this.primary
+}
+
+// UsingFallback reports whether output is going to the fallback writer.
+func (this *SwitchWriter) UsingFallback() bool {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.fallback != nil
+}
+
+// RingBuffer is an io.Writer that keeps the most recent writes in memory.
+type RingBuffer struct {
+	mu sync.Mutex
+	bufs [][]byte
+	next int
+	full bool
+	onDrop func()
+}
+
+// NewRingBuffer returns a RingBuffer holding up to n writes.
+func NewRingBuffer(n int) (this *RingBuffer) {
+
+	if n < 1 {
+		n = 1
+	}
+
+	return &RingBuffer{bufs: make([][]byte, n)}
+}
+
+// OnDrop sets a function called, with the lock held, for each write that
+// is discarded.
+func (this *RingBuffer) OnDrop(fn func()) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onDrop = fn
+}
+
+// Write stores a copy of b, discarding the oldest write if the buffer is
+// full.
+func (this *RingBuffer) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.full && this.onDrop != nil {
+		this.onDrop()
+	}
+
+	this.bufs[this.next] = append([]byte(nil), b...)
+
+	if this.next++; this.next == len(this.bufs) {
+		this.next, this.full = 0, true
+	}
+
+	return len(b), nil
+}
+
+// Contents returns the stored writes, oldest first.
+func (this *RingBuffer) Contents() (bufs [][]byte) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.contents()
+}
+
+// contents returns the stored writes, oldest first. The caller must hold
+// the lock.
+func (this *RingBuffer) contents() (bufs [][]byte) {
+
+	if this.full {
+		bufs = append(bufs, this.bufs[this.next:]...)
+	}
+
+	return append(bufs, this.bufs[:this.next]...)
+}
+
+// WriteTo writes the stored writes to w, oldest first, and empties the
+// buffer.
+func (this *RingBuffer) WriteTo(w io.Writer) (n int64, err error) {
+
+	this.mu.Lock()
+
+	bufs := this.contents()
+	this.bufs = make([][]byte, len(this.bufs))
+	this.next, this.full = 0, false
+
+	this.mu.Unlock()
+
+	for _, b := range bufs {
+		m, err := w.Write(b)
+		if n += int64(m); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// DiskGuard watches the free space of the directories its writers log
+// to. When space in a directory drops below a threshold, the SwitchWriters
+// writing there are redirected to their fallbacks and an alert is logged;
+// when space recovers, they are restored. Output held by a RingBuffer
+// fallback is replayed to the primary writer on recovery.
+type DiskGuard struct {
+	mu sync.Mutex
+	minFree uint64
+	resumeFree uint64
+	alert *log.Logger
+	guarded []guardedWriter
+	low map[string]bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+type guardedWriter struct {
+	name string
+	dir string
+	sw *SwitchWriter
+	fallback io.Writer
+}
+
+// diskFree measures free space for DiskGuard; tests replace it.
+var diskFree = DiskFree
+
+// NewDiskGuard returns a DiskGuard that switches writers to fallbacks when
+// free space in their directory falls below minFree bytes and back when it
+// reaches resumeFree. If resumeFree is less than minFree, minFree is used.
+// Alerts are written to alert, which should not depend on the guarded
+// files.
+func NewDiskGuard(minFree, resumeFree uint64, alert *log.Logger) (this *DiskGuard) {
+
+	if resumeFree < minFree {
+		resumeFree = minFree
+	}
+
+	return &DiskGuard{
+		minFree: minFree,
+		resumeFree: resumeFree,
+		alert: alert,
+		low: make(map[string]bool),
+	}
+}
+
+// Add registers a SwitchWriter whose primary writer logs to a file in dir,
+// and the fallback to use when space in dir is low.
+func (this *DiskGuard) Add(name, dir string, sw *SwitchWriter, fallback io.Writer) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.guarded = append(this.guarded, guardedWriter{name, dir, sw, fallback})
+}
+
+// Low reports whether the guard has switched any writer to its fallback.
+func (this *DiskGuard) Low() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return len(this.low) > 0
+}
+
+// Check measures the free space of each directory and switches the
+// writers in it if the threshold has been crossed. An error measuring one
+// directory does not stop the others being checked; the first is
+// returned.
+func (this *DiskGuard) Check() (err error) {
+
+	this.mu.Lock()
+
+	var dirs []string
+	seen := make(map[string]bool)
+
+	for _, g := range this.guarded {
+		if !seen[g.dir] {
+			seen[g.dir] = true
+			dirs = append(dirs, g.dir)
+		}
+	}
+
+	this.mu.Unlock()
+
+	for _, dir := range dirs {
+
+		free, derr := diskFree(dir)
+
+		if derr != nil {
+			if err == nil {
+				err = derr
+			}
+			continue
+		}
+
+		this.check(dir, free)
+	}
+
+	return err
+}
+
+// check switches the writers in dir if free crosses a threshold.
+func (this *DiskGuard) check(dir string, free uint64) {
+
+	this.mu.Lock()
+
+	switch {
+
+	case !this.low[dir] && free < this.minFree:
+
+		this.low[dir] = true
+
+		for _, g := range this.guarded {
+			if g.dir == dir {
+				g.sw.Fallback(g.fallback)
+			}
+		}
+
+		this.mu.Unlock()
+
+		if this.alert != nil {
+			this.alert.Printf(`disk guard: %d bytes free in %s, below %d; logging to fallback`,
+				free, dir, this.minFree)
+		}
+
+	case this.low[dir] && free >= this.resumeFree:
+
+		delete(this.low, dir)
+
+		for _, g := range this.guarded {
+			if g.dir != dir {
+				continue
+			}
+			if _, err := g.sw.Restore(); err != nil {
+				reportError(ErrorDecorator(err))
+			}
+		}
+
+		this.mu.Unlock()
+
+		if this.alert != nil {
+			this.alert.Printf(`disk guard: %d bytes free in %s; logging to files resumed`,
+				free, dir)
+		}
+
+	default:
+		this.mu.Unlock()
+	}
+}
+
+// Start checks free space immediately and then at every interval in the
+// background until Stop is called.
+func (this *DiskGuard) Start(interval time.Duration) {
+
+	this.mu.Lock()
+
+	if this.stop != nil {
+		this.mu.Unlock()
+		return
+	}
+
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+	stop, done := this.stop, this.done
+
+	this.mu.Unlock()
+
+	go func() {
+
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := this.Check(); err != nil {
+				reportError(ErrorDecorator(err))
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop halts background checking and waits for it to finish.
+func (this *DiskGuard) Stop() {
+
+	this.mu.Lock()
+	stop, done := this.stop, this.done
+	this.stop, this.done = nil, nil
+	this.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`errors`
+	`log`
+	`strings`
+	`sync`
+	`testing`
+	`time`
+)
+
+// fakeDiskFree replaces diskFree with a lookup in a map of directories to
+// free bytes for the duration of a test.
+func fakeDiskFree(t *testing.T) (free map[string]uint64, mu *sync.Mutex) {
+
+	free, mu = make(map[string]uint64), new(sync.Mutex)
+	prev := diskFree
+
+	diskFree = func(dir string) (uint64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if n, ok := free[dir]; ok {
+			return n, nil
+		}
+		return 0, errors.New(`no such directory: ` + dir)
+	}
+
+	t.Cleanup(func() { diskFree = prev })
+
+	return free, mu
+}
+
+// gateWriter records each write as it arrives, then blocks the first one
+// until release is closed.
+type gateWriter struct {
+	lockedBuffer
+	once sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (this *gateWriter) Write(b []byte) (int, error) {
+
+	n, err := this.lockedBuffer.Write(b)
+
+	this.once.Do(func() {
+		close(this.entered)
+		<-this.release
+	})
+
+	return n, err
+}
+
+func TestRingBuffer(t *testing.T) {
+
+	var drops int
+
+	rb := NewRingBuffer(3)
+	rb.OnDrop(func() { drops++ })
+
+	for _, s := range []string{`1`, `2`, `3`, `4`, `5`} {
+		rb.Write([]byte(s))
+	}
+
+	if drops != 2 {
+		t.Errorf(`drops = %d, want 2`, drops)
+	}
+
+	bb := new(bytes.Buffer)
+
+	if n, err := rb.WriteTo(bb); err != nil || n != 3 {
+		t.Fatalf(`WriteTo = %d, %v`, n, err)
+	}
+
+	if bb.String() != `345` {
+		t.Errorf(`replayed %q, want "345"`, bb.String())
+	}
+
+	if bufs := rb.Contents(); len(bufs) != 0 {
+		t.Errorf(`buffer not emptied: %q`, bufs)
+	}
+}
+
+func TestDiskGuardPerDirectory(t *testing.T) {
+
+	free, mu := fakeDiskFree(t)
+	free[`/a`], free[`/b`] = 1000, 1000
+
+	alerts := new(lockedBuffer)
+	dg := NewDiskGuard(100, 200, log.New(alerts, ``, 0))
+
+	swA := NewSwitchWriter(new(bytes.Buffer))
+	swB := NewSwitchWriter(new(bytes.Buffer))
+	swC := NewSwitchWriter(new(bytes.Buffer))
+
+	dg.Add(`a`, `/a`, swA, new(bytes.Buffer))
+	dg.Add(`b`, `/b`, swB, new(bytes.Buffer))
+	dg.Add(`c`, `/b`, swC, new(bytes.Buffer))
+
+	if err := dg.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dg.Low() {
+		t.Fatal(`low with space in every directory`)
+	}
+
+	mu.Lock()
+	free[`/b`] = 50
+	mu.Unlock()
+
+	if err := dg.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !dg.Low() {
+		t.Error(`not low with /b full`)
+	}
+
+	if swA.UsingFallback() {
+		t.Error(`writer in /a switched for low space in /b`)
+	}
+
+	if !swB.UsingFallback() || !swC.UsingFallback() {
+		t.Error(`writers in /b not switched`)
+	}
+
+	if !strings.Contains(alerts.String(), `50 bytes free in /b`) {
+		t.Errorf(`alert does not name /b: %q`, alerts.String())
+	}
+
+	// Recovery needs resumeFree, not just minFree.
+
+	mu.Lock()
+	free[`/b`] = 150
+	mu.Unlock()
+
+	dg.Check()
+
+	if !swB.UsingFallback() {
+		t.Error(`restored below resumeFree`)
+	}
+
+	mu.Lock()
+	free[`/b`] = 200
+	mu.Unlock()
+
+	dg.Check()
+
+	if swB.UsingFallback() || swC.UsingFallback() || dg.Low() {
+		t.Error(`writers in /b not restored`)
+	}
+}
+
+func TestDiskGuardCheckError(t *testing.T) {
+
+	free, _ := fakeDiskFree(t)
+	free[`/b`] = 50
+
+	dg := NewDiskGuard(100, 100, nil)
+
+	swA := NewSwitchWriter(new(bytes.Buffer))
+	swB := NewSwitchWriter(new(bytes.Buffer))
+
+	dg.Add(`a`, `/missing`, swA, new(bytes.Buffer))
+	dg.Add(`b`, `/b`, swB, new(bytes.Buffer))
+
+	if err := dg.Check(); err == nil {
+		t.Error(`no error for a missing directory`)
+	}
+
+	if !swB.UsingFallback() {
+		t.Error(`error in one directory stopped the others being checked`)
+	}
+}
+
+func TestDiskGuardReplayBlocksWrites(t *testing.T) {
+
+	free, mu := fakeDiskFree(t)
+	free[`/a`] = 50
+
+	primary := newGateWriter()
+	rb := NewRingBuffer(10)
+	sw := NewSwitchWriter(primary)
+
+	dg := NewDiskGuard(100, 100, nil)
+	dg.Add(`a`, `/a`, sw, rb)
+	dg.Check()
+
+	sw.Write([]byte("one\n"))
+	sw.Write([]byte("two\n"))
+
+	mu.Lock()
+	free[`/a`] = 1000
+	mu.Unlock()
+
+	checked := make(chan struct{})
+
+	go func() {
+		dg.Check()
+		close(checked)
+	}()
+
+	// The replay is now blocked writing "one". A new write must wait for
+	// it to finish rather than reach the file between replayed lines.
+
+	<-primary.entered
+
+	written := make(chan struct{})
+
+	go func() {
+		sw.Write([]byte("three\n"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Error(`write completed during replay`)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(primary.release)
+	<-checked
+	<-written
+
+	if got, want := primary.String(), "one\ntwo\nthree\n"; got != want {
+		t.Errorf(`primary got %q, want %q`, got, want)
+	}
+}
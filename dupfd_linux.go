@@ -0,0 +1,41 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package goutil
+
+import (
+	`syscall`
+)
+
+// dupFD returns a close-on-exec duplicate of fd.
+func dupFD(fd int) (int, error) {
+
+	nfd, err := syscall.Dup(fd)
+
+	if err != nil {
+		return -1, err
+	}
+
+	syscall.CloseOnExec(nfd)
+
+	return nfd, nil
+}
+
+// redirectFD makes newfd refer to the same file as oldfd.
+func redirectFD(oldfd, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
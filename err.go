@@ -16,8 +16,16 @@ package goutil
 
 import (
 	`fmt`
+	`log`
 	`runtime`
 	`path/filepath`
+	`sync`
+)
+
+var (
+	errMu sync.Mutex
+	errLogger *log.Logger
+	errHook func(error)
 )
 
 // ErrorDecorator prepends function filename, line number, and function name
@@ -45,3 +53,45 @@ func ErrorDecorator(err error) (error) {
 		err,
 	)
 }
+
+// reportError logs an error that cannot be returned to the caller. While
+// the standard logger is redirected to a channel, the error is written to
+// the standard logger's original output instead, so that a failure on the
+// channel's own write path cannot re-enter the standard logger, which
+// holds its lock while writing.
+func reportError(err error) {
+
+	errMu.Lock()
+	l, hook := errLogger, errHook
+	errMu.Unlock()
+
+	if l != nil {
+		l.Print(err)
+	} else {
+		log.Print(err)
+	}
+
+	if hook != nil {
+		hook(err)
+	}
+}
+
+// SetErrorHook sets a function to be called, after the error is logged,
+// with every error that goutil cannot return to its caller, such as a
+// failed file rotation. It returns the previous hook; nil removes it. The
+// logtest package uses it to fail tests on such errors.
+func SetErrorHook(fn func(err error)) (prev func(error)) {
+	errMu.Lock()
+	defer errMu.Unlock()
+	prev, errHook = errHook, fn
+	return prev
+}
+
+// setErrorLogger sets the logger used by reportError and returns the
+// previous one. A nil logger selects the standard logger.
+func setErrorLogger(l *log.Logger) (prev *log.Logger) {
+	errMu.Lock()
+	defer errMu.Unlock()
+	prev, errLogger = errLogger, l
+	return prev
+}
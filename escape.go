@@ -0,0 +1,397 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bufio`
+	`bytes`
+	`fmt`
+	`io`
+	`regexp`
+	`strconv`
+	`strings`
+	`sync`
+	`unicode/utf8`
+)
+
+// Escaping policies for EscapeWriter. With EscapeNone messages are passed
+// through unchanged. EscapeControl replaces backslashes, control
+// characters, including the C1 controls, the Unicode line and paragraph
+// separators and bytes that are not valid UTF-8 with backslash escapes.
+// EscapeQuote writes messages that contain any of these as Go-quoted
+// strings. EscapeContinuation writes each embedded line on its own
+// physical line prefixed with ContinuationMarker; carriage returns, NEL
+// and the Unicode separators also end a line.
+const (
+	EscapeNone = `none`
+	EscapeControl = `escape`
+	EscapeQuote = `quote`
+	EscapeContinuation = `continuation`
+
+	ContinuationMarker = "\t"
+)
+
+// ValidEscaping reports whether s names an escaping policy. The empty
+// string is treated as EscapeNone.
+func ValidEscaping(s string) bool {
+	switch s {
+	case ``, EscapeNone, EscapeControl, EscapeQuote, EscapeContinuation:
+		return true
+	default:
+		return false
+	}
+}
+
+// EscapeWriter is an io.Writer that encodes each message written to it so
+// that it occupies exactly one logical line, preventing user input from
+// forging additional log entries. Each call to Write is treated as one
+// message, as with log.Logger; a single trailing newline is the message
+// terminator. Buffered output should reach it through a MessageWriter.
+type EscapeWriter struct {
+	w io.Writer
+	policy string
+}
+
+// NewEscapeWriter returns an EscapeWriter applying policy to output sent
+// to w.
+func NewEscapeWriter(w io.Writer, policy string) (this *EscapeWriter, err error) {
+
+	if !ValidEscaping(policy) {
+		return nil, fmt.Errorf(`invalid escaping policy %q`, policy)
+	}
+
+	return &EscapeWriter{w, policy}, nil
+}
+
+// Write encodes the message in b and writes it to the underlying writer.
+// It returns len(b) on success.
+func (this *EscapeWriter) Write(b []byte) (n int, err error) {
+
+	msg := strings.TrimSuffix(string(b), "\n")
+
+	if _, err = io.WriteString(this.w, EscapeMessage(msg, this.policy) + "\n"); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// MessageWriter passes each newline-terminated line written to it to an
+// underlying writer as a separate Write, holding a final unterminated
+// line until it is completed or Flush is called. It lets buffered output,
+// which may flush several lines or part of one at a time, reach writers
+// such as EscapeWriter that treat each Write as one message.
+type MessageWriter struct {
+	mu sync.Mutex
+	w io.Writer
+	buf []byte
+}
+
+// NewMessageWriter returns a MessageWriter that writes to w.
+func NewMessageWriter(w io.Writer) (this *MessageWriter) {
+	return &MessageWriter{w: w}
+}
+
+// Write writes each complete line in b to the underlying writer. It
+// returns len(b) unless a write fails.
+func (this *MessageWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.buf = append(this.buf, b...)
+
+	for {
+
+		i := bytes.IndexByte(this.buf, '\n')
+
+		if i < 0 {
+			break
+		}
+
+		line := this.buf[:i+1]
+		this.buf = this.buf[i+1:]
+
+		if _, err = this.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(this.buf) == 0 {
+		this.buf = nil
+	}
+
+	return len(b), nil
+}
+
+// Flush writes a held unterminated line as a message of its own.
+func (this *MessageWriter) Flush() (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if len(this.buf) == 0 {
+		return nil
+	}
+
+	line := append(this.buf, '\n')
+	this.buf = nil
+
+	_, err = this.w.Write(line)
+
+	return err
+}
+
+// EscapeMessage encodes a message according to policy.
+func EscapeMessage(msg, policy string) string {
+
+	switch policy {
+
+	case EscapeControl:
+
+		bb := new(bytes.Buffer)
+
+		// Work through the bytes rather than ranging over runes, which
+		// would turn invalid UTF-8 into U+FFFD.
+
+		for i := 0; i < len(msg); {
+
+			r, size := utf8.DecodeRuneInString(msg[i:])
+
+			switch {
+			case r == '\\':
+				bb.WriteString(`\\`)
+			case r == '\n':
+				bb.WriteString(`\n`)
+			case r == '\r':
+				bb.WriteString(`\r`)
+			case r == '\t':
+				bb.WriteString(`\t`)
+			case r == utf8.RuneError && size == 1, r < 0x20, r == 0x7f:
+				fmt.Fprintf(bb, `\x%02x`, msg[i])
+			case unsafeRune(r):
+				fmt.Fprintf(bb, `\u%04x`, r)
+			default:
+				bb.WriteString(msg[i:i+size])
+			}
+
+			i += size
+		}
+
+		return bb.String()
+
+	case EscapeQuote:
+
+		if strings.HasPrefix(msg, `"`) || hasControl(msg) {
+			return strconv.Quote(msg)
+		}
+
+		return msg
+
+	case EscapeContinuation:
+
+		return continuationRe.ReplaceAllString(msg, "\n" + ContinuationMarker)
+
+	default:
+		return msg
+	}
+}
+
+// UnescapeMessage reverses EscapeMessage for the EscapeControl and
+// EscapeQuote policies. Continuation lines are joined by MessageScanner.
+func UnescapeMessage(line, policy string) (string, error) {
+
+	switch policy {
+
+	case EscapeControl:
+
+		bb := new(bytes.Buffer)
+
+		for i := 0; i < len(line); i++ {
+
+			if line[i] != '\\' || i == len(line) - 1 {
+				bb.WriteByte(line[i])
+				continue
+			}
+
+			i++
+
+			switch line[i] {
+			case '\\':
+				bb.WriteByte('\\')
+			case 'n':
+				bb.WriteByte('\n')
+			case 'r':
+				bb.WriteByte('\r')
+			case 't':
+				bb.WriteByte('\t')
+			case 'x':
+				if i + 2 >= len(line) {
+					return ``, fmt.Errorf(`truncated escape in %q`, line)
+				}
+				v, err := strconv.ParseUint(line[i+1:i+3], 16, 8)
+				if err != nil {
+					return ``, fmt.Errorf(`invalid escape in %q`, line)
+				}
+				bb.WriteByte(byte(v))
+				i += 2
+			case 'u':
+				if i + 4 >= len(line) {
+					return ``, fmt.Errorf(`truncated escape in %q`, line)
+				}
+				v, err := strconv.ParseUint(line[i+1:i+5], 16, 16)
+				if err != nil {
+					return ``, fmt.Errorf(`invalid escape in %q`, line)
+				}
+				bb.WriteRune(rune(v))
+				i += 4
+			default:
+				return ``, fmt.Errorf(`invalid escape in %q`, line)
+			}
+		}
+
+		return bb.String(), nil
+
+	case EscapeQuote:
+
+		if strings.HasPrefix(line, `"`) {
+			return strconv.Unquote(line)
+		}
+
+		return line, nil
+
+	default:
+		return line, nil
+	}
+}
+
+// continuationRe matches the line endings that EscapeContinuation breaks
+// lines at.
+var continuationRe = regexp.MustCompile("\r\n|[\n\r\u0085\u2028\u2029]")
+
+// hasControl reports whether s contains a control character, an unsafe
+// rune or invalid UTF-8.
+func hasControl(s string) bool {
+
+	if !utf8.ValidString(s) {
+		return true
+	}
+
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f || unsafeRune(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unsafeRune reports whether r is a C1 control character or a Unicode
+// line or paragraph separator, which some terminals and log viewers treat
+// as line breaks or escape sequences.
+func unsafeRune(r rune) bool {
+	return r >= 0x80 && r <= 0x9f || r == 0x2028 || r == 0x2029
+}
+
+// escapeFormatter applies an escaping policy to the message of each record
+// before formatting it. In record mode it takes the place of EscapeWriter
+// for the text and RFC 5424 formats, so that the message is escaped after
+// the record is parsed and only for the formats that do not encode it.
+type escapeFormatter struct {
+	f RecordFormatter
+	policy string
+}
+
+// Format escapes the record's message and formats the record.
+func (this escapeFormatter) Format(r *Record) []byte {
+	c := *r
+	c.Message = EscapeMessage(r.Message, this.policy)
+	return this.f.Format(&c)
+}
+
+// MessageScanner reads a log written through an EscapeWriter and returns
+// the original, possibly multi-line, messages.
+type MessageScanner struct {
+	sc *bufio.Scanner
+	policy string
+	next *string
+	msg string
+	err error
+}
+
+// NewMessageScanner returns a MessageScanner decoding r with policy.
+func NewMessageScanner(r io.Reader, policy string) (this *MessageScanner) {
+
+	this = &MessageScanner{sc: bufio.NewScanner(r), policy: policy}
+	this.sc.Buffer(nil, 1024 * 1024)
+
+	return this
+}
+
+// Scan advances to the next message, returning false at the end of the
+// input or on error.
+func (this *MessageScanner) Scan() bool {
+
+	if this.err != nil {
+		return false
+	}
+
+	var line string
+
+	if this.next != nil {
+		line, this.next = *this.next, nil
+	} else if this.sc.Scan() {
+		line = this.sc.Text()
+	} else {
+		this.err = this.sc.Err()
+		return false
+	}
+
+	if this.policy == EscapeContinuation {
+
+		for this.sc.Scan() {
+
+			text := this.sc.Text()
+
+			if !strings.HasPrefix(text, ContinuationMarker) {
+				this.next = &text
+				break
+			}
+
+			line += "\n" + strings.TrimPrefix(text, ContinuationMarker)
+		}
+
+		if this.next == nil {
+			this.err = this.sc.Err()
+		}
+
+		this.msg = line
+		return true
+	}
+
+	this.msg, this.err = UnescapeMessage(line, this.policy)
+
+	return this.err == nil
+}
+
+// Text returns the most recent message read by Scan.
+func (this *MessageScanner) Text() string {
+	return this.msg
+}
+
+// Err returns the first error encountered, if any.
+func (this *MessageScanner) Err() error {
+	return this.err
+}
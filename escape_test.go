@@ -0,0 +1,174 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bufio`
+	`bytes`
+	`encoding/json`
+	`fmt`
+	`io/ioutil`
+	`path/filepath`
+	`regexp`
+	`testing`
+)
+
+// newEscapedWriter returns a MultiLoggerWriter whose system channel is
+// escaped with policy and written only to bb.
+func newEscapedWriter(bb *bytes.Buffer, policy string) *MultiLoggerWriter {
+	return new(MultiLoggerWriter).
+		Defaults().
+		EnableLogFiles(false).
+		EnableConsole(false).
+		EnableSyslog(false).
+		SystemEscaping(policy).
+		AddWriter(SystemChannel, bb).
+		Init()
+}
+
+func TestEscapeBufWriterLines(t *testing.T) {
+
+	bb := new(bytes.Buffer)
+	mlw := newEscapedWriter(bb, EscapeControl)
+	bw := mlw.GetSystemBufWriter().(*bufio.Writer)
+
+	fmt.Fprint(bw, "line one\nline two\n")
+
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := bb.String(), "line one\nline two\n"; got != want {
+		t.Errorf(`got %q, want %q`, got, want)
+	}
+}
+
+func TestEscapeBufWriterPartialLine(t *testing.T) {
+
+	bb := new(bytes.Buffer)
+	mlw := newEscapedWriter(bb, EscapeControl)
+	bw := mlw.GetSystemBufWriter().(*bufio.Writer)
+
+	fmt.Fprint(bw, "tab\there\nunfinished")
+	bw.Flush()
+
+	if got, want := bb.String(), "tab\\there\n"; got != want {
+		t.Errorf(`before Flush: got %q, want %q`, got, want)
+	}
+
+	if err := mlw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := bb.String(), "tab\\there\nunfinished\n"; got != want {
+		t.Errorf(`after Flush: got %q, want %q`, got, want)
+	}
+}
+
+func TestEscapeLoggerMessage(t *testing.T) {
+
+	bb := new(bytes.Buffer)
+	mlw := newEscapedWriter(bb, EscapeControl)
+
+	mlw.GetWriter(SystemChannel).Write([]byte("one\nforged\n"))
+
+	if got, want := bb.String(), "one\\nforged\n"; got != want {
+		t.Errorf(`got %q, want %q`, got, want)
+	}
+}
+
+func TestEscapeMessage(t *testing.T) {
+
+	for _, tc := range []struct {
+		policy string
+		msg string
+		want string
+	}{
+		{EscapeControl, "ok caf\u00e9 \ufffd", "ok caf\u00e9 \ufffd"},
+		{EscapeControl, "a\\b\tc\r\nd\x1b[31m", `a\\b\tc\r\nd\x1b[31m`},
+		{EscapeControl, "bad\xff\xfe utf-8", `bad\xff\xfe utf-8`},
+		{EscapeControl, "c1\u0085\u009b line\u2028para\u2029", `c1\u0085\u009b line\u2028para\u2029`},
+		{EscapeQuote, `plain`, `plain`},
+		{EscapeQuote, "bad\xff", `"bad\xff"`},
+		{EscapeQuote, "nel\u0085", `"nel\u0085"`},
+		{EscapeQuote, "sep\u2028", `"sep\u2028"`},
+		{EscapeContinuation, "a\r\nb\rc\u0085d\u2028e\u2029f\ng", "a\n\tb\n\tc\n\td\n\te\n\tf\n\tg"},
+		{EscapeContinuation, "bad\xff", "bad\xff"},
+	} {
+
+		got := EscapeMessage(tc.msg, tc.policy)
+
+		if got != tc.want {
+			t.Errorf(`%s %q: got %q, want %q`, tc.policy, tc.msg, got, tc.want)
+			continue
+		}
+
+		if tc.policy == EscapeContinuation {
+			continue
+		}
+
+		if back, err := UnescapeMessage(got, tc.policy); err != nil || back != tc.msg {
+			t.Errorf(`%s %q: unescaped to %q, %v`, tc.policy, tc.msg, back, err)
+		}
+	}
+}
+
+func TestEscapeRecordMode(t *testing.T) {
+
+	dir := t.TempDir()
+	bb := new(bytes.Buffer)
+
+	mlw := new(MultiLoggerWriter).
+		Defaults().
+		EnableConsole(false).
+		EnableSyslog(false).
+		LogDir(dir).
+		SystemLink(``).
+		SystemEscaping(EscapeQuote).
+		SystemFormat(SinkFormats{File: FormatJSON}).
+		AddWriter(SystemChannel, bb).
+		Init()
+
+	mlw.GetLogger(SystemChannel).Print("one\nforged")
+	mlw.Close()
+
+	// The file and line are parsed before the message is quoted for the
+	// text sink.
+
+	if !regexp.MustCompile(`^system .*escape_test\.go:\d+: "one\\nforged"\n$`).MatchString(bb.String()) {
+		t.Errorf(`text sink got %q`, bb.String())
+	}
+
+	// The JSON sink encodes the message once.
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, `system.log`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec struct {
+		File string `json:"file"`
+		Msg string `json:"msg"`
+	}
+
+	if err = json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf(`%v in %q`, err, b)
+	}
+
+	if rec.Msg != "one\nforged" || filepath.Base(rec.File) != `escape_test.go` {
+		t.Errorf(`JSON sink got %+v`, rec)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`fmt`
+	`os`
+	`os/signal`
+	`sync`
+	`syscall`
+	`time`
+)
+
+// exitHooks is the registry of functions run by RunExitHooks.
+var exitHooks struct {
+	mu sync.Mutex
+	hooks map[int]func() error
+	order []int
+	next int
+}
+
+// OnExit registers fn to be run by RunExitHooks, which runs hooks in the
+// reverse order of registration. The returned function removes the hook.
+func OnExit(fn func() error) (remove func()) {
+
+	exitHooks.mu.Lock()
+	defer exitHooks.mu.Unlock()
+
+	if exitHooks.hooks == nil {
+		exitHooks.hooks = make(map[int]func() error)
+	}
+
+	id := exitHooks.next
+	exitHooks.next++
+
+	exitHooks.hooks[id] = fn
+	exitHooks.order = append(exitHooks.order, id)
+
+	return func() {
+
+		exitHooks.mu.Lock()
+		defer exitHooks.mu.Unlock()
+
+		delete(exitHooks.hooks, id)
+
+		for i, oid := range exitHooks.order {
+			if oid == id {
+				exitHooks.order = append(exitHooks.order[:i:i], exitHooks.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// RunExitHooks runs and removes every registered hook, most recent first,
+// and waits up to timeout for them to finish. Errors returned by hooks are
+// logged. An error is returned if the hooks did not finish in time.
+func RunExitHooks(timeout time.Duration) error {
+
+	exitHooks.mu.Lock()
+
+	var hooks []func() error
+
+	for i := len(exitHooks.order) - 1; i >= 0; i-- {
+		if fn, ok := exitHooks.hooks[exitHooks.order[i]]; ok {
+			hooks = append(hooks, fn)
+		}
+	}
+
+	exitHooks.hooks, exitHooks.order = nil, nil
+
+	exitHooks.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for _, fn := range hooks {
+			if err := fn(); err != nil {
+				reportError(ErrorDecorator(err))
+			}
+		}
+	}()
+
+	if timeout <= 0 {
+		<-done
+		return nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf(`exit hooks did not finish within %v`, timeout)
+	}
+}
+
+// Exit runs the exit hooks, waiting up to timeout, and then terminates the
+// process with the given status code.
+func Exit(code int, timeout time.Duration) {
+
+	if err := RunExitHooks(timeout); err != nil {
+		reportError(ErrorDecorator(err))
+	}
+
+	os.Exit(code)
+}
+
+// HandleSignals installs a handler that runs the exit hooks and exits when
+// the process receives SIGINT or SIGTERM. The exit status is 128 plus the
+// signal number, as a shell would report it. The returned function removes
+// the handler.
+func HandleSignals(timeout time.Duration) (stop func()) {
+
+	ch := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			Exit(code, timeout)
+		case <-quit:
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`errors`
+	`io`
+	`log`
+	`strings`
+	`sync`
+	`testing`
+	`time`
+)
+
+// isolateExitHooks empties the exit hook registry for the duration of a
+// test, so that hooks left by other tests are neither run nor lost.
+func isolateExitHooks(t *testing.T) {
+
+	exitHooks.mu.Lock()
+	hooks, order := exitHooks.hooks, exitHooks.order
+	exitHooks.hooks, exitHooks.order = nil, nil
+	exitHooks.mu.Unlock()
+
+	t.Cleanup(func() {
+		exitHooks.mu.Lock()
+		exitHooks.hooks, exitHooks.order = hooks, order
+		exitHooks.mu.Unlock()
+	})
+}
+
+func TestExitHooksOrder(t *testing.T) {
+
+	isolateExitHooks(t)
+
+	var ran []string
+
+	hook := func(name string) func() error {
+		return func() error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+
+	OnExit(hook(`first`))
+	remove := OnExit(hook(`removed`))
+	OnExit(hook(`last`))
+
+	remove()
+	remove()
+
+	if n := len(exitHooks.order); n != 2 {
+		t.Errorf(`%d hooks in the registry order after removal, want 2`, n)
+	}
+
+	if err := RunExitHooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(ran, ` `); got != `last first` {
+		t.Errorf(`ran %q, want "last first"`, got)
+	}
+
+	// The hooks are removed once run.
+
+	ran = nil
+
+	if err := RunExitHooks(time.Second); err != nil || len(ran) != 0 {
+		t.Errorf(`second run: ran %q, %v`, ran, err)
+	}
+}
+
+func TestExitHooksTimeout(t *testing.T) {
+
+	isolateExitHooks(t)
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	var later bool
+
+	OnExit(func() error {
+		defer close(finished)
+		later = true
+		return nil
+	})
+
+	OnExit(func() error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	err := RunExitHooks(50 * time.Millisecond)
+
+	if err == nil || !strings.Contains(err.Error(), `did not finish within 50ms`) {
+		t.Errorf(`RunExitHooks returned %v, want a timeout`, err)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf(`RunExitHooks took %v with a 50ms timeout`, d)
+	}
+
+	// The remaining hooks still run once the hung one returns.
+
+	close(release)
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal(`hook after the hung one never ran`)
+	}
+
+	if !later {
+		t.Error(`hook after the hung one did not run`)
+	}
+}
+
+func TestExitHooksNoTimeout(t *testing.T) {
+
+	isolateExitHooks(t)
+
+	var ran bool
+
+	OnExit(func() error {
+		time.Sleep(20 * time.Millisecond)
+		ran = true
+		return nil
+	})
+
+	if err := RunExitHooks(0); err != nil || !ran {
+		t.Errorf(`RunExitHooks(0) returned %v before the hook finished`, err)
+	}
+}
+
+func TestExitHooksError(t *testing.T) {
+
+	isolateExitHooks(t)
+
+	defer setErrorLogger(setErrorLogger(log.New(io.Discard, ``, 0)))
+
+	var (
+		mu sync.Mutex
+		reported []error
+	)
+
+	defer SetErrorHook(SetErrorHook(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	}))
+
+	errHookFailed := errors.New(`hook failed`)
+
+	var ran bool
+
+	OnExit(func() error { ran = true; return nil })
+	OnExit(func() error { return errHookFailed })
+
+	if err := RunExitHooks(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), `hook failed`) {
+		t.Errorf(`reported %v`, reported)
+	}
+
+	if !ran {
+		t.Error(`a failing hook stopped the next one`)
+	}
+}
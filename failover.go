@@ -0,0 +1,387 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`fmt`
+	`io`
+	`sync`
+	`time`
+)
+
+// HealthChecker is implemented by writers that can report whether they
+// are able to accept output without writing to them.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// healthChecker returns the HealthChecker of a writer, looking through
+// wrappers that have an Unwrap method returning the writer they wrap.
+func healthChecker(w io.Writer) (HealthChecker, bool) {
+
+	for w != nil {
+
+		if hc, ok := w.(HealthChecker); ok {
+			return hc, true
+		}
+
+		u, ok := w.(interface{ Unwrap() io.Writer })
+
+		if !ok {
+			break
+		}
+
+		w = u.Unwrap()
+	}
+
+	return nil, false
+}
+
+// FailoverStatus describes one sink of a FailoverWriter.
+type FailoverStatus struct {
+	Name string
+	Active bool
+	Healthy bool
+	LastError string
+	FailedAt time.Time
+}
+
+// FailoverWriter is an io.Writer that sends each write to the first
+// healthy sink in an ordered chain. A sink that fails is skipped until the
+// retry interval has passed, after which it is tried again, so output
+// returns to a preferred sink once it recovers.
+type FailoverWriter struct {
+	mu sync.Mutex
+	sinks []*failoverSink
+	active int
+	retry time.Duration
+	onSkip func(string)
+	stop chan struct{}
+	done chan struct{}
+}
+
+type failoverSink struct {
+	name string
+	w io.Writer
+	err error
+	failedAt time.Time
+}
+
+// NewFailoverWriter returns an empty FailoverWriter that retries failed
+// sinks after the given interval.
+func NewFailoverWriter(retry time.Duration) (this *FailoverWriter) {
+	return &FailoverWriter{retry: retry, active: -1}
+}
+
+// Add appends a sink to the end of the chain.
+func (this *FailoverWriter) Add(name string, w io.Writer) *FailoverWriter {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.sinks = append(this.sinks, &failoverSink{name: name, w: w})
+	return this
+}
+
+// OnSkip sets a function called, with the lock held, with the name of
+// each failed sink that a write passes over while waiting to retry it.
+func (this *FailoverWriter) OnSkip(fn func(sink string)) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onSkip = fn
+}
+
+// Write writes b to the first sink that accepts it. An error is returned
+// only if every sink fails.
+func (this *FailoverWriter) Write(b []byte) (n int, err error) {
+	return this.write(func(w io.Writer) (int, error) {
+		return w.Write(b)
+	})
+}
+
+// WriteRecord writes r to the first sink that accepts it, as Write does.
+// Sinks that implement RecordSink format the record themselves; the
+// others receive it rendered by text.
+func (this *FailoverWriter) WriteRecord(r *Record, text RecordFormatter) (n int, err error) {
+
+	var b []byte
+
+	return this.write(func(w io.Writer) (int, error) {
+		if rs, ok := w.(RecordSink); ok {
+			return rs.WriteRecord(r)
+		}
+		if b == nil {
+			b = text.Format(r)
+		}
+		return w.Write(b)
+	})
+}
+
+// write calls fn with each sink in turn until one succeeds.
+func (this *FailoverWriter) write(fn func(io.Writer) (int, error)) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	now := time.Now()
+	tried := make([]bool, len(this.sinks))
+
+	for i, s := range this.sinks {
+
+		if s.err != nil && now.Sub(s.failedAt) < this.retry {
+			if this.onSkip != nil {
+				this.onSkip(s.name)
+			}
+			continue
+		}
+
+		tried[i] = true
+
+		if n, err = fn(s.w); err == nil {
+			s.err = nil
+			this.active = i
+			return n, nil
+		}
+
+		s.err, s.failedAt = err, now
+	}
+
+	// Every sink is failing or waiting to be retried; try the waiting
+	// ones rather than lose the message.
+
+	for i, s := range this.sinks {
+
+		if tried[i] {
+			continue
+		}
+
+		if n, err = fn(s.w); err == nil {
+			s.err = nil
+			this.active = i
+			return n, nil
+		}
+
+		s.err, s.failedAt = err, now
+	}
+
+	this.active = -1
+
+	if err == nil {
+		err = fmt.Errorf(`no sinks configured`)
+	}
+
+	return 0, err
+}
+
+// Check probes every failed sink that implements HealthChecker, directly
+// or through wrappers with an Unwrap method, and marks it healthy if the
+// probe succeeds, so that the next write fails back to it without waiting
+// for the retry interval. Probes, which may dial, run without the lock,
+// so writes are not held up by them.
+func (this *FailoverWriter) Check() {
+
+	type probe struct {
+		s *failoverSink
+		hc HealthChecker
+		err error
+	}
+
+	var probes []*probe
+
+	this.mu.Lock()
+
+	for _, s := range this.sinks {
+		if hc, ok := healthChecker(s.w); ok && s.err != nil {
+			probes = append(probes, &probe{s: s, hc: hc})
+		}
+	}
+
+	this.mu.Unlock()
+
+	for _, p := range probes {
+		p.err = p.hc.Healthy()
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	// A sink that a write found working meanwhile keeps that result.
+
+	for _, p := range probes {
+		switch {
+		case p.s.err == nil:
+		case p.err == nil:
+			p.s.err = nil
+		default:
+			p.s.err, p.s.failedAt = p.err, time.Now()
+		}
+	}
+}
+
+// Start runs Check at every interval in the background until Stop is
+// called.
+func (this *FailoverWriter) Start(interval time.Duration) {
+
+	this.mu.Lock()
+
+	if this.stop != nil || interval <= 0 {
+		this.mu.Unlock()
+		return
+	}
+
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+	stop, done := this.stop, this.done
+
+	this.mu.Unlock()
+
+	go func() {
+
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				this.Check()
+			}
+		}
+	}()
+}
+
+// Stop halts background health checks and waits for them to finish.
+func (this *FailoverWriter) Stop() {
+
+	this.mu.Lock()
+	stop, done := this.stop, this.done
+	this.stop, this.done = nil, nil
+	this.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Active returns the name of the sink that accepted the last write, or an
+// empty string if none has.
+func (this *FailoverWriter) Active() string {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.active < 0 {
+		return ``
+	}
+
+	return this.sinks[this.active].name
+}
+
+// Status describes every sink in chain order.
+func (this *FailoverWriter) Status() (fs []FailoverStatus) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for i, s := range this.sinks {
+
+		st := FailoverStatus{
+			Name: s.name,
+			Active: i == this.active,
+			Healthy: s.err == nil,
+			FailedAt: s.failedAt,
+		}
+
+		if s.err != nil {
+			st.LastError = s.err.Error()
+		}
+
+		fs = append(fs, st)
+	}
+
+	return fs
+}
+
+// RedialWriter is an io.Writer that opens its destination on first use
+// and again after a write error, for sinks such as syslog that may be
+// unavailable when the program starts.
+type RedialWriter struct {
+	mu sync.Mutex
+	dial func() (io.Writer, error)
+	w io.Writer
+}
+
+// NewRedialWriter returns a RedialWriter that uses dial to open its
+// destination.
+func NewRedialWriter(dial func() (io.Writer, error)) (this *RedialWriter) {
+	return &RedialWriter{dial: dial}
+}
+
+// Write writes to the destination, dialing it first if necessary. After
+// a write error the destination is closed and dialed again on next use.
+func (this *RedialWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.w == nil {
+		if this.w, err = this.dial(); err != nil {
+			this.w = nil
+			return 0, err
+		}
+	}
+
+	if n, err = this.w.Write(b); err != nil {
+		this.close()
+	}
+
+	return n, err
+}
+
+// Healthy dials the destination if it is not open.
+func (this *RedialWriter) Healthy() (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.w == nil {
+		if this.w, err = this.dial(); err != nil {
+			this.w = nil
+		}
+	}
+
+	return err
+}
+
+// Close closes the destination if it is open.
+func (this *RedialWriter) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.close()
+}
+
+// close closes the destination. The caller must hold the lock.
+func (this *RedialWriter) close() (err error) {
+
+	if c, ok := this.w.(io.Closer); ok {
+		err = c.Close()
+	}
+
+	this.w = nil
+
+	return err
+}
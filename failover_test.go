@@ -0,0 +1,87 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`errors`
+	`testing`
+	`time`
+)
+
+// slowProbe is a sink that fails writes and whose health probe blocks
+// until released.
+type slowProbe struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (this *slowProbe) Write(b []byte) (int, error) {
+	return 0, errors.New(`down`)
+}
+
+func (this *slowProbe) Healthy() error {
+	close(this.entered)
+	<-this.release
+	return nil
+}
+
+func TestFailoverCheckDoesNotBlockWrites(t *testing.T) {
+
+	probe := &slowProbe{make(chan struct{}), make(chan struct{})}
+	bb := new(bytes.Buffer)
+
+	fw := NewFailoverWriter(time.Hour).Add(`slow`, probe).Add(`buffer`, bb)
+
+	if _, err := fw.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	checked := make(chan struct{})
+
+	go func() {
+		fw.Check()
+		close(checked)
+	}()
+
+	<-probe.entered
+
+	written := make(chan error, 1)
+
+	go func() {
+		_, err := fw.Write([]byte("two\n"))
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal(`write blocked by health check`)
+	}
+
+	close(probe.release)
+	<-checked
+
+	if st := fw.Status(); !st[0].Healthy {
+		t.Errorf(`probed sink not marked healthy: %+v`, st[0])
+	}
+
+	if bb.String() != "one\ntwo\n" {
+		t.Errorf(`buffer got %q`, bb.String())
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`fmt`
+	`os`
+	`path/filepath`
+	`sync`
+	`text/template`
+	`time`
+)
+
+// LogFileData holds the values available to log file name templates,
+// e.g. "{{.AppName}}-{{.Host}}-{{.PID}}-{{.Date}}.log".
+type LogFileData struct {
+	AppName string
+	Host string
+	PID int
+	Date string
+}
+
+// LogFile is an io.WriteCloser that writes to a file whose directory and
+// name are expanded from templates. The templates are re-evaluated when
+// the date changes, and an optional symlink is kept pointing at the file
+// currently being written.
+type LogFile struct {
+	mu sync.Mutex
+	dir *template.Template
+	name *template.Template
+	link string
+	data LogFileData
+	path string
+	fh *os.File
+}
+
+// NewLogFile parses the directory and file name templates and opens the
+// file for the current date. If link is not empty and differs from the
+// expanded file name, a symlink with that name is created in the log
+// directory and moved to each new file on rollover.
+func NewLogFile(dir, name, link, appName string) (this *LogFile, err error) {
+
+	this = &LogFile{link: link}
+
+	if this.dir, err = template.New(`dir`).Parse(dir); err != nil {
+		return nil, err
+	}
+
+	if this.name, err = template.New(`name`).Parse(name); err != nil {
+		return nil, err
+	}
+
+	this.data.AppName = appName
+	this.data.PID = os.Getpid()
+
+	if this.data.Host, err = os.Hostname(); err != nil {
+		this.data.Host = `localhost`
+	}
+
+	if err = this.open(time.Now().Format(LogFileDateLayout)); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Name returns the path of the file currently being written.
+func (this *LogFile) Name() string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.path
+}
+
+// Write writes to the current file, first rolling over to a new file if
+// the date has changed since the file was opened. If the new file cannot
+// be opened, output continues to go to the previous file.
+func (this *LogFile) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if date := time.Now().Format(LogFileDateLayout); date != this.data.Date {
+		if err = this.open(date); err != nil {
+			reportError(ErrorDecorator(err))
+			this.data.Date = date
+		}
+	}
+
+	return this.fh.Write(b)
+}
+
+// Sync commits the current file to stable storage.
+func (this *LogFile) Sync() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.fh.Sync()
+}
+
+// Close closes the current file.
+func (this *LogFile) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.fh.Close()
+}
+
+// open expands the templates for the given date and opens the resulting
+// file, closing the previous one. The caller must hold the lock.
+func (this *LogFile) open(date string) (err error) {
+
+	var dir, name, path string
+
+	data := this.data
+	data.Date = date
+
+	if dir, err = this.expand(this.dir, data); err != nil {
+		return err
+	}
+
+	if name, err = this.expand(this.name, data); err != nil {
+		return err
+	}
+
+	if path = name; !filepath.IsAbs(path) {
+		path = filepath.Join(dir, name)
+	}
+
+	fh, err := MkdirOpen(path)
+
+	if err != nil {
+		return err
+	}
+
+	if this.fh != nil {
+		this.fh.Close()
+	}
+
+	this.fh, this.path, this.data = fh, path, data
+
+	if this.link != `` && this.link != filepath.Base(path) {
+		if err := this.relink(); err != nil {
+			reportError(ErrorDecorator(err))
+		}
+	}
+
+	return nil
+}
+
+// relink atomically points the symlink at the current file. Only a
+// symlink is replaced: a regular file in its place, such as one written
+// before templated names were configured, is first renamed aside as a
+// rotated copy, and anything else is an error.
+func (this *LogFile) relink() (err error) {
+
+	link := this.link
+
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(filepath.Dir(this.path), link)
+	}
+
+	if fi, err := os.Lstat(link); err == nil && fi.Mode() & os.ModeSymlink == 0 {
+
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf(`%s: not a symlink`, link)
+		}
+
+		if os.SameFile(fi, this.stat()) {
+			return fmt.Errorf(`%s: is the current log file`, link)
+		}
+
+		backup := link + `.` + time.Now().Format(RotateTimeLayout)
+
+		if err = os.Rename(link, backup); err != nil {
+			return err
+		}
+
+		reportError(ErrorDecorator(fmt.Errorf(`%s: regular file moved to %s`, link, backup)))
+	}
+
+	target := this.path
+
+	if filepath.Dir(link) == filepath.Dir(target) {
+		target = filepath.Base(target)
+	}
+
+	tmp := fmt.Sprintf(`%s.%d.tmp`, link, os.Getpid())
+	os.Remove(tmp)
+
+	if err = os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
+
+// stat returns the FileInfo of the current file, or nil.
+func (this *LogFile) stat() os.FileInfo {
+	fi, _ := this.fh.Stat()
+	return fi
+}
+
+// expand executes a template with the given data.
+func (this *LogFile) expand(t *template.Template, data LogFileData) (s string, err error) {
+
+	bb := new(bytes.Buffer)
+
+	if err = t.Execute(bb, data); err != nil {
+		return s, err
+	}
+
+	return bb.String(), nil
+}
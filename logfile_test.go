@@ -0,0 +1,246 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+	`time`
+)
+
+// readFile returns the contents of a file or fails the test.
+func readFile(t *testing.T, path string) string {
+
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// checkLink fails the test unless link is a symlink resolving to path.
+func checkLink(t *testing.T, link, path string) {
+
+	t.Helper()
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode() & os.ModeSymlink == 0 {
+		t.Fatalf(`%s is not a symlink: %v`, link, err)
+	}
+
+	lfi, err := os.Stat(link)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfi, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !os.SameFile(lfi, pfi) {
+		t.Errorf(`%s does not point at %s`, link, path)
+	}
+}
+
+func TestLogFileTemplate(t *testing.T) {
+
+	tmp := t.TempDir()
+	host, _ := os.Hostname()
+	date := time.Now().Format(LogFileDateLayout)
+
+	lf, err := NewLogFile(filepath.Join(tmp, `{{.AppName}}`),
+		`{{.AppName}}-{{.Host}}-{{.PID}}-{{.Date}}.log`, `system.log`, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	want := filepath.Join(tmp, `app`, fmt.Sprintf(`app-%s-%d-%s.log`, host, os.Getpid(), date))
+
+	if lf.Name() != want {
+		t.Fatalf(`Name() = %q, want %q`, lf.Name(), want)
+	}
+
+	if _, err := lf.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, want); got != "hello\n" {
+		t.Errorf(`file holds %q`, got)
+	}
+
+	checkLink(t, filepath.Join(tmp, `app`, `system.log`), want)
+
+	if _, err := NewLogFile(tmp, `{{.Bogus`, ``, `app`); err == nil {
+		t.Error(`no error for a malformed template`)
+	}
+}
+
+func TestLogFileRollover(t *testing.T) {
+
+	tmp := t.TempDir()
+
+	lf, err := NewLogFile(tmp, `app-{{.Date}}.log`, `system.log`, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	// Reopen under an earlier date, as if the file had been opened
+	// yesterday; the next write must roll over to today's file.
+
+	lf.mu.Lock()
+	err = lf.open(`2001-02-03`)
+	lf.mu.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := filepath.Join(tmp, `app-2001-02-03.log`)
+	checkLink(t, filepath.Join(tmp, `system.log`), old)
+
+	lf.mu.Lock()
+	lf.fh.Write([]byte("yesterday\n"))
+	lf.mu.Unlock()
+
+	if _, err := lf.Write([]byte("today\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	cur := filepath.Join(tmp, `app-` + time.Now().Format(LogFileDateLayout) + `.log`)
+
+	if lf.Name() != cur {
+		t.Fatalf(`Name() = %q after rollover, want %q`, lf.Name(), cur)
+	}
+
+	if got := readFile(t, old); got != "yesterday\n" {
+		t.Errorf(`old file holds %q`, got)
+	}
+
+	if got := readFile(t, cur); got != "today\n" {
+		t.Errorf(`new file holds %q`, got)
+	}
+
+	checkLink(t, filepath.Join(tmp, `system.log`), cur)
+}
+
+func TestLogFileLinkSameName(t *testing.T) {
+
+	tmp := t.TempDir()
+
+	lf, err := NewLogFile(tmp, `system.log`, `system.log`, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	if fi, err := os.Lstat(filepath.Join(tmp, `system.log`)); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf(`file named as its link is not a regular file: %v`, err)
+	}
+}
+
+func TestLogFileLinkReplacesRegularFile(t *testing.T) {
+
+	defer setErrorLogger(setErrorLogger(log.New(io.Discard, ``, 0)))
+
+	var reported []error
+	defer SetErrorHook(SetErrorHook(func(err error) { reported = append(reported, err) }))
+
+	tmp := t.TempDir()
+	link := filepath.Join(tmp, `system.log`)
+
+	// A log written before templated names were configured.
+
+	if err := os.WriteFile(link, []byte("before upgrade\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, err := NewLogFile(tmp, `app-{{.Date}}.log`, `system.log`, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	checkLink(t, link, lf.Name())
+
+	backups, _ := filepath.Glob(link + `.*`)
+
+	if len(backups) != 1 {
+		t.Fatalf(`regular file not moved aside: %q`, backups)
+	}
+
+	if _, _, ok := backupStamp(link, backups[0]); !ok {
+		t.Errorf(`%s is not named as a rotated copy`, backups[0])
+	}
+
+	if got := readFile(t, backups[0]); got != "before upgrade\n" {
+		t.Errorf(`moved file holds %q`, got)
+	}
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), `moved to`) {
+		t.Errorf(`move not reported: %v`, reported)
+	}
+}
+
+func TestLogFileLinkRefusesDirectory(t *testing.T) {
+
+	defer setErrorLogger(setErrorLogger(log.New(io.Discard, ``, 0)))
+
+	var reported []error
+	defer SetErrorHook(SetErrorHook(func(err error) { reported = append(reported, err) }))
+
+	tmp := t.TempDir()
+	link := filepath.Join(tmp, `system.log`)
+
+	if err := os.Mkdir(link, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, err := NewLogFile(tmp, `app-{{.Date}}.log`, `system.log`, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lf.Close()
+
+	if fi, err := os.Lstat(link); err != nil || !fi.IsDir() {
+		t.Errorf(`directory in the link's place was replaced: %v`, err)
+	}
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), `not a symlink`) {
+		t.Errorf(`refusal not reported: %v`, reported)
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logtest provides a MultiLoggerWriter whose channels write to
+// in-memory recorders, for unit testing code that logs through goutil.
+package logtest
+
+import (
+	`regexp`
+	`strings`
+	`sync`
+	`testing`
+	`github.com/jscherff/goutil`
+)
+
+var channels = []goutil.LogChannel{
+	goutil.SystemChannel,
+	goutil.AccessChannel,
+	goutil.ErrorChannel,
+}
+
+// Recorder captures every line written to each channel of its embedded
+// MultiLoggerWriter, and the errors goutil reports rather than returns.
+// Files, console and syslog output are disabled. As goutil has a single
+// error hook, errors are recorded by the most recently created Recorder.
+type Recorder struct {
+	*goutil.MultiLoggerWriter
+	t testing.TB
+	mu sync.Mutex
+	lines map[goutil.LogChannel][]string
+	partial map[goutil.LogChannel]string
+	errors []string
+}
+
+// channelWriter appends the lines it receives to one channel of a Recorder.
+type channelWriter struct {
+	r *Recorder
+	c goutil.LogChannel
+}
+
+// Write records each newline-terminated line in b. A final line without a
+// newline is held until the rest of it is written.
+func (this *channelWriter) Write(b []byte) (n int, err error) {
+
+	this.r.mu.Lock()
+	defer this.r.mu.Unlock()
+
+	lines := strings.Split(this.r.partial[this.c] + string(b), "\n")
+
+	this.r.lines[this.c] = append(this.r.lines[this.c], lines[:len(lines) - 1]...)
+	this.r.partial[this.c] = lines[len(lines) - 1]
+
+	return len(b), nil
+}
+
+// New returns an initialized Recorder bound to t. It is closed during
+// cleanup and, if the test failed, the recorded output of every channel
+// is written to the test log.
+func New(t testing.TB) (this *Recorder) {
+
+	this = &Recorder{
+		t: t,
+		lines: make(map[goutil.LogChannel][]string),
+		partial: make(map[goutil.LogChannel]string),
+	}
+
+	mlw := new(goutil.MultiLoggerWriter).
+		Defaults().
+		EnableLogFiles(false).
+		EnableConsole(false).
+		EnableSyslog(false).
+		SystemUseFlags(false).
+		AccessUseFlags(false).
+		ErrorUseFlags(false)
+
+	for _, c := range channels {
+		mlw.AddWriter(c, &channelWriter{this, c})
+	}
+
+	this.MultiLoggerWriter = mlw.Init()
+
+	prev := goutil.SetErrorHook(this.reportError)
+
+	t.Cleanup(func() {
+		this.Close()
+		goutil.SetErrorHook(prev)
+		this.flush()
+		if t.Failed() {
+			this.dump()
+		}
+	})
+
+	return this
+}
+
+// Lines returns a copy of the lines recorded on a channel.
+func (this *Recorder) Lines(c goutil.LogChannel) []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]string(nil), this.lines[c]...)
+}
+
+// Errors returns a copy of the errors goutil reported.
+func (this *Recorder) Errors() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]string(nil), this.errors...)
+}
+
+// Reset discards all recorded lines and errors.
+func (this *Recorder) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.lines = make(map[goutil.LogChannel][]string)
+	this.partial = make(map[goutil.LogChannel]string)
+	this.errors = nil
+}
+
+// Count returns the number of lines recorded on a channel.
+func (this *Recorder) Count(c goutil.LogChannel) int {
+	return len(this.Lines(c))
+}
+
+// CountLevel returns the number of lines on a channel at a level: "debug",
+// "info", "warn", "error" or "fatal", or a synonym such as "warning". A
+// line's level is the first level word after the channel's tag, as for
+// goutil.MatchLevel; a line without one is at info. The test fails if the
+// level is not known.
+func (this *Recorder) CountLevel(c goutil.LogChannel, level string) (n int) {
+
+	this.t.Helper()
+
+	want, ok := goutil.ParseLevel(level)
+
+	if !ok {
+		this.t.Fatalf(`logtest: unknown level %q`, level)
+	}
+
+	tag := this.tag(c)
+
+	for _, s := range this.Lines(c) {
+
+		got := goutil.FindLevel([]byte(strings.TrimPrefix(s, tag)))
+
+		if got == `` {
+			got = `info`
+		}
+
+		if got == want {
+			n++
+		}
+	}
+
+	return n
+}
+
+// CountContaining returns the number of lines on a channel that contain
+// substr, e.g. a level word such as "WARN".
+func (this *Recorder) CountContaining(c goutil.LogChannel, substr string) (n int) {
+
+	for _, s := range this.Lines(c) {
+		if strings.Contains(s, substr) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// CountMatching returns the number of lines on a channel that match the
+// regular expression. The test fails if the expression does not compile.
+func (this *Recorder) CountMatching(c goutil.LogChannel, expr string) (n int) {
+
+	this.t.Helper()
+
+	re, err := regexp.Compile(expr)
+
+	if err != nil {
+		this.t.Fatalf(`logtest: %v`, err)
+	}
+
+	for _, s := range this.Lines(c) {
+		if re.MatchString(s) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// AssertContains fails the test unless a line on the channel contains substr.
+func (this *Recorder) AssertContains(c goutil.LogChannel, substr string) {
+	this.t.Helper()
+	if this.CountContaining(c, substr) == 0 {
+		this.t.Errorf(`logtest: no %s line contains %q`, c, substr)
+	}
+}
+
+// AssertNotContains fails the test if a line on the channel contains substr.
+func (this *Recorder) AssertNotContains(c goutil.LogChannel, substr string) {
+	this.t.Helper()
+	if n := this.CountContaining(c, substr); n > 0 {
+		this.t.Errorf(`logtest: %d %s lines contain %q`, n, c, substr)
+	}
+}
+
+// AssertMatch fails the test unless a line on the channel matches expr.
+func (this *Recorder) AssertMatch(c goutil.LogChannel, expr string) {
+	this.t.Helper()
+	if this.CountMatching(c, expr) == 0 {
+		this.t.Errorf(`logtest: no %s line matches %q`, c, expr)
+	}
+}
+
+// AssertCount fails the test unless the channel has exactly n lines.
+func (this *Recorder) AssertCount(c goutil.LogChannel, n int) {
+	this.t.Helper()
+	if got := this.Count(c); got != n {
+		this.t.Errorf(`logtest: %s has %d lines, want %d`, c, got, n)
+	}
+}
+
+// AssertNoErrors fails the test if anything was written to the Error
+// channel or goutil reported an error it could not return.
+func (this *Recorder) AssertNoErrors() {
+	this.t.Helper()
+	if lines := this.Lines(goutil.ErrorChannel); len(lines) > 0 {
+		this.t.Errorf("logtest: %d lines logged to error:\n\t%s",
+			len(lines), strings.Join(lines, "\n\t"))
+	}
+	if errs := this.Errors(); len(errs) > 0 {
+		this.t.Errorf("logtest: %d errors reported by goutil:\n\t%s",
+			len(errs), strings.Join(errs, "\n\t"))
+	}
+}
+
+// reportError records an error reported by goutil.
+func (this *Recorder) reportError(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.errors = append(this.errors, err.Error())
+}
+
+// tag returns the tag that begins the lines of a channel.
+func (this *Recorder) tag(c goutil.LogChannel) string {
+	switch c {
+	case goutil.AccessChannel:
+		return this.Config.LogTags.Access
+	case goutil.ErrorChannel:
+		return this.Config.LogTags.Error
+	default:
+		return this.Config.LogTags.System
+	}
+}
+
+// flush records the partial line of each channel, if any.
+func (this *Recorder) flush() {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for c, s := range this.partial {
+		if s != `` {
+			this.lines[c] = append(this.lines[c], s)
+		}
+	}
+
+	this.partial = make(map[goutil.LogChannel]string)
+}
+
+// dump writes the recorded output of every channel to the test log.
+func (this *Recorder) dump() {
+
+	this.t.Helper()
+
+	for _, c := range channels {
+		if lines := this.Lines(c); len(lines) > 0 {
+			this.t.Logf("logtest: %s output:\n\t%s", c, strings.Join(lines, "\n\t"))
+		}
+	}
+
+	if errs := this.Errors(); len(errs) > 0 {
+		this.t.Logf("logtest: errors:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+}
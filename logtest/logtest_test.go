@@ -0,0 +1,145 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logtest
+
+import (
+	`fmt`
+	`os`
+	`path/filepath`
+	`testing`
+	`github.com/jscherff/goutil`
+)
+
+// fakeTB records failures instead of failing the test, and runs cleanups
+// when told to.
+type fakeTB struct {
+	testing.TB
+	errors []string
+	cleanups []func()
+}
+
+func (this *fakeTB) Helper() {}
+
+func (this *fakeTB) Errorf(format string, v ...interface{}) {
+	this.errors = append(this.errors, fmt.Sprintf(format, v...))
+}
+
+func (this *fakeTB) Cleanup(fn func()) {
+	this.cleanups = append(this.cleanups, fn)
+}
+
+func (this *fakeTB) Failed() bool {
+	return len(this.errors) > 0
+}
+
+func (this *fakeTB) Logf(format string, v ...interface{}) {}
+
+func (this *fakeTB) cleanup() {
+	for i := len(this.cleanups) - 1; i >= 0; i-- {
+		this.cleanups[i]()
+	}
+}
+
+func TestRecorderLines(t *testing.T) {
+
+	r := New(t)
+	r.GetLogger(goutil.SystemChannel).Print(`first`)
+	r.GetLogger(goutil.SystemChannel).Print("second\nthird")
+
+	r.AssertCount(goutil.SystemChannel, 3)
+	r.AssertContains(goutil.SystemChannel, `first`)
+	r.AssertNotContains(goutil.AccessChannel, `first`)
+	r.AssertMatch(goutil.SystemChannel, `^third$`)
+	r.AssertNoErrors()
+
+	if r.Reset(); r.Count(goutil.SystemChannel) != 0 {
+		t.Error(`Reset kept lines`)
+	}
+}
+
+func TestRecorderPartialLines(t *testing.T) {
+
+	r := New(t)
+	w := &channelWriter{r, goutil.AccessChannel}
+
+	for _, s := range []string{`GET `, "/a 200\nGET /b", " 404\n", `GET /c`} {
+		w.Write([]byte(s))
+	}
+
+	lines := r.Lines(goutil.AccessChannel)
+
+	if len(lines) != 2 || lines[0] != `GET /a 200` || lines[1] != `GET /b 404` {
+		t.Errorf(`lines %q`, lines)
+	}
+
+	// The unterminated line is recorded when the test ends.
+
+	r.flush()
+
+	if lines = r.Lines(goutil.AccessChannel); len(lines) != 3 || lines[2] != `GET /c` {
+		t.Errorf(`lines after flush %q`, lines)
+	}
+}
+
+func TestRecorderCountLevel(t *testing.T) {
+
+	r := New(t)
+	l := r.GetLogger(goutil.ErrorChannel)
+
+	l.Print(`ERROR: disk full`)
+	l.Print(`warning: retrying`)
+	l.Print(`WARN: retrying again`)
+	l.Print(`connection reset`)
+
+	for level, want := range map[string]int{`error`: 1, `warning`: 2, `info`: 1, `debug`: 0} {
+		if got := r.CountLevel(goutil.ErrorChannel, level); got != want {
+			t.Errorf(`%s: %d lines, want %d`, level, got, want)
+		}
+	}
+}
+
+func TestRecorderAssertNoErrors(t *testing.T) {
+
+	tb := new(fakeTB)
+	r := New(tb)
+
+	r.AssertNoErrors()
+
+	if tb.Failed() {
+		t.Fatalf(`failed without errors: %q`, tb.errors)
+	}
+
+	// AddFile reports, rather than returns, a file it cannot create.
+
+	f := filepath.Join(t.TempDir(), `file`)
+
+	if err := os.WriteFile(f, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	goutil.NewMultiWriter().AddFile(filepath.Join(f, `test.log`))
+
+	if r.AssertNoErrors(); !tb.Failed() {
+		t.Error(`reported error not caught`)
+	}
+
+	tb.cleanup()
+
+	goutil.NewMultiWriter().AddFile(filepath.Join(f, `test.log`))
+
+	if len(r.Errors()) != 1 {
+		t.Errorf(`error hook not removed: %q`, r.Errors())
+	}
+}
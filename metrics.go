@@ -0,0 +1,308 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`fmt`
+	`io`
+	`net/http`
+	`sort`
+	`sync`
+	`time`
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the channel and sink
+// write latency histograms.
+var LatencyBuckets = []float64{
+	.0001, .0005, .001, .005, .01, .05, .1, .5, 1,
+}
+
+// LogMetrics collects line, byte, error, drop and latency counters for
+// each channel and sink of a MultiLoggerWriter. It implements http.Handler
+// and serves the counters in the Prometheus text exposition format.
+type LogMetrics struct {
+	mu sync.Mutex
+	channels map[string]*channelMetrics
+	sinks map[sinkKey]*sinkMetrics
+}
+
+type sinkKey struct {
+	channel string
+	sink string
+}
+
+type channelMetrics struct {
+	latency
+	lines uint64
+	bytes uint64
+}
+
+type sinkMetrics struct {
+	latency
+	lines uint64
+	bytes uint64
+	errors uint64
+	drops uint64
+}
+
+// latency is a histogram of write latencies over LatencyBuckets.
+type latency struct {
+	buckets []uint64
+	count uint64
+	sum float64
+}
+
+// observe adds a write latency to the histogram.
+func (this *latency) observe(d time.Duration) {
+
+	secs := d.Seconds()
+
+	if this.buckets == nil {
+		this.buckets = make([]uint64, len(LatencyBuckets))
+	}
+
+	for i, ub := range LatencyBuckets {
+		if secs <= ub {
+			this.buckets[i]++
+		}
+	}
+
+	this.count++
+	this.sum += secs
+}
+
+// write writes the histogram's samples in the Prometheus text exposition
+// format, with the given metric name and labels.
+func (this *latency) write(bb *bytes.Buffer, name, labels string) {
+
+	for i, ub := range LatencyBuckets {
+
+		var n uint64
+
+		if this.buckets != nil {
+			n = this.buckets[i]
+		}
+
+		fmt.Fprintf(bb, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, ub, n)
+	}
+
+	fmt.Fprintf(bb, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, this.count)
+	fmt.Fprintf(bb, "%s_sum{%s} %g\n", name, labels, this.sum)
+	fmt.Fprintf(bb, "%s_count{%s} %d\n", name, labels, this.count)
+}
+
+// NewLogMetrics returns an initialized LogMetrics object.
+func NewLogMetrics() (this *LogMetrics) {
+	return &LogMetrics{
+		channels: make(map[string]*channelMetrics),
+		sinks: make(map[sinkKey]*sinkMetrics),
+	}
+}
+
+// ChannelWriter wraps a channel's writer so that every line and byte
+// written to the channel, and the latency of each write across all of its
+// sinks, is counted.
+func (this *LogMetrics) ChannelWriter(channel string, w io.Writer) io.Writer {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.channel(channel)
+	return &meteredWriter{this, channel, ``, w}
+}
+
+// SinkWriter wraps one of a channel's sinks so that lines, bytes, errors
+// and write latency are counted for that sink. If the sink discards
+// messages and reports them through an OnDrop method, as NetWriter and
+// RingBuffer do, those are counted as drops.
+func (this *LogMetrics) SinkWriter(channel, sink string, w io.Writer) io.Writer {
+
+	if d, ok := w.(interface{ OnDrop(func()) }); ok {
+		d.OnDrop(func() { this.Drop(channel, sink) })
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.sink(channel, sink)
+
+	return &meteredWriter{this, channel, sink, w}
+}
+
+// Drop records a message that was discarded before reaching a sink.
+func (this *LogMetrics) Drop(channel, sink string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.sink(channel, sink).drops++
+}
+
+// channel returns the counters for a channel, creating them if necessary.
+// The caller must hold the lock.
+func (this *LogMetrics) channel(channel string) *channelMetrics {
+
+	cm, ok := this.channels[channel]
+
+	if !ok {
+		cm = new(channelMetrics)
+		this.channels[channel] = cm
+	}
+
+	return cm
+}
+
+// sink returns the counters for a sink, creating them if necessary. The
+// caller must hold the lock.
+func (this *LogMetrics) sink(channel, sink string) *sinkMetrics {
+
+	key := sinkKey{channel, sink}
+	sm, ok := this.sinks[key]
+
+	if !ok {
+		sm = new(sinkMetrics)
+		this.sinks[key] = sm
+	}
+
+	return sm
+}
+
+// record updates the counters after a write.
+func (this *LogMetrics) record(channel, sink string, b []byte, err error, d time.Duration) {
+
+	lines := uint64(bytes.Count(b, []byte("\n")))
+
+	if lines == 0 && len(b) > 0 {
+		lines = 1
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if sink == `` {
+		cm := this.channel(channel)
+		cm.lines += lines
+		cm.bytes += uint64(len(b))
+		cm.observe(d)
+		return
+	}
+
+	sm := this.sink(channel, sink)
+
+	if err != nil {
+		sm.errors++
+	} else {
+		sm.lines += lines
+		sm.bytes += uint64(len(b))
+	}
+
+	sm.observe(d)
+}
+
+// ServeHTTP writes all counters in the Prometheus text exposition format.
+func (this *LogMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(`Content-Type`, `text/plain; version=0.0.4; charset=utf-8`)
+	this.WriteTo(w)
+}
+
+// WriteTo writes all counters to w in the Prometheus text exposition format.
+func (this *LogMetrics) WriteTo(w io.Writer) (n int64, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	bb := new(bytes.Buffer)
+
+	var channels []string
+
+	for c := range this.channels {
+		channels = append(channels, c)
+	}
+
+	sort.Strings(channels)
+
+	var keys []sinkKey
+
+	for k := range this.sinks {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].channel != keys[j].channel {
+			return keys[i].channel < keys[j].channel
+		}
+		return keys[i].sink < keys[j].sink
+	})
+
+	var header = func(name, typ, help string) {
+		fmt.Fprintf(bb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header(`goutil_log_channel_lines_total`, `counter`, `Lines written to each channel.`)
+	for _, c := range channels {
+		fmt.Fprintf(bb, "goutil_log_channel_lines_total{channel=%q} %d\n", c, this.channels[c].lines)
+	}
+
+	header(`goutil_log_channel_bytes_total`, `counter`, `Bytes written to each channel.`)
+	for _, c := range channels {
+		fmt.Fprintf(bb, "goutil_log_channel_bytes_total{channel=%q} %d\n", c, this.channels[c].bytes)
+	}
+
+	header(`goutil_log_channel_write_seconds`, `histogram`, `Latency of writes to each channel, across its sinks.`)
+	for _, c := range channels {
+		this.channels[c].write(bb, `goutil_log_channel_write_seconds`, fmt.Sprintf(`channel=%q`, c))
+	}
+
+	var counter = func(name, help string, val func(*sinkMetrics) uint64) {
+		header(name, `counter`, help)
+		for _, k := range keys {
+			fmt.Fprintf(bb, "%s{channel=%q,sink=%q} %d\n", name, k.channel, k.sink, val(this.sinks[k]))
+		}
+	}
+
+	counter(`goutil_log_sink_lines_total`, `Lines successfully written to each sink.`,
+		func(sm *sinkMetrics) uint64 { return sm.lines })
+	counter(`goutil_log_sink_bytes_total`, `Bytes successfully written to each sink.`,
+		func(sm *sinkMetrics) uint64 { return sm.bytes })
+	counter(`goutil_log_sink_write_errors_total`, `Failed writes to each sink.`,
+		func(sm *sinkMetrics) uint64 { return sm.errors })
+	counter(`goutil_log_sink_drops_total`, `Messages discarded before reaching each sink.`,
+		func(sm *sinkMetrics) uint64 { return sm.drops })
+
+	header(`goutil_log_sink_write_seconds`, `histogram`, `Latency of writes to each sink.`)
+	for _, k := range keys {
+		this.sinks[k].write(bb, `goutil_log_sink_write_seconds`, fmt.Sprintf(`channel=%q,sink=%q`, k.channel, k.sink))
+	}
+
+	return bb.WriteTo(w)
+}
+
+// meteredWriter is an io.Writer that records metrics for each write.
+type meteredWriter struct {
+	m *LogMetrics
+	channel string
+	sink string
+	w io.Writer
+}
+
+// Write writes to the underlying writer and records the outcome.
+func (this *meteredWriter) Write(b []byte) (n int, err error) {
+	start := time.Now()
+	n, err = this.w.Write(b)
+	this.m.record(this.channel, this.sink, b, err, time.Since(start))
+	return n, err
+}
+
+// Unwrap returns the underlying writer.
+func (this *meteredWriter) Unwrap() io.Writer {
+	return this.w
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`strconv`
+	`strings`
+	`testing`
+	`time`
+)
+
+// slowWriter sleeps before every write.
+type slowWriter struct {
+	d time.Duration
+}
+
+func (this slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(this.d)
+	return len(b), nil
+}
+
+// metricSamples parses Prometheus text output into a map from series to
+// value, failing the test on lines that are not comments or samples.
+func metricSamples(t *testing.T, s string) map[string]string {
+
+	samples := make(map[string]string)
+
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+
+		if strings.HasPrefix(line, `# HELP `) || strings.HasPrefix(line, `# TYPE `) {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ' ')
+
+		if i < 0 {
+			t.Fatalf(`malformed line %q`, line)
+		}
+
+		samples[line[:i]] = line[i+1:]
+	}
+
+	return samples
+}
+
+func TestLogMetrics(t *testing.T) {
+
+	m := NewLogMetrics()
+
+	cw := m.ChannelWriter(`system`, slowWriter{20 * time.Millisecond})
+	good := m.SinkWriter(`system`, `file`, new(bytes.Buffer))
+	bad := m.SinkWriter(`system`, `writer0`, &failWriter{})
+
+	cw.Write([]byte("one\ntwo\n"))
+	good.Write([]byte("one\ntwo\n"))
+	good.Write([]byte(`three`))
+	bad.Write([]byte("one\n"))
+	m.Drop(`system`, `writer0`)
+
+	bb := new(bytes.Buffer)
+
+	if _, err := m.WriteTo(bb); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []string{
+		`goutil_log_channel_lines_total counter`,
+		`goutil_log_channel_write_seconds histogram`,
+		`goutil_log_sink_drops_total counter`,
+		`goutil_log_sink_write_seconds histogram`,
+	} {
+		if !strings.Contains(bb.String(), "# TYPE " + typ + "\n") {
+			t.Errorf(`no TYPE line for %s`, typ)
+		}
+	}
+
+	samples := metricSamples(t, bb.String())
+
+	for series, want := range map[string]string{
+		`goutil_log_channel_lines_total{channel="system"}`: `2`,
+		`goutil_log_channel_bytes_total{channel="system"}`: `8`,
+		`goutil_log_sink_lines_total{channel="system",sink="file"}`: `3`,
+		`goutil_log_sink_bytes_total{channel="system",sink="file"}`: `13`,
+		`goutil_log_sink_lines_total{channel="system",sink="writer0"}`: `0`,
+		`goutil_log_sink_write_errors_total{channel="system",sink="writer0"}`: `1`,
+		`goutil_log_sink_drops_total{channel="system",sink="writer0"}`: `1`,
+		`goutil_log_sink_drops_total{channel="system",sink="file"}`: `0`,
+
+		// The channel's single write took at least 20ms, so it is not
+		// in the buckets below that.
+
+		`goutil_log_channel_write_seconds_bucket{channel="system",le="0.01"}`: `0`,
+		`goutil_log_channel_write_seconds_bucket{channel="system",le="1"}`: `1`,
+		`goutil_log_channel_write_seconds_bucket{channel="system",le="+Inf"}`: `1`,
+		`goutil_log_channel_write_seconds_count{channel="system"}`: `1`,
+
+		`goutil_log_sink_write_seconds_bucket{channel="system",sink="file",le="1"}`: `2`,
+		`goutil_log_sink_write_seconds_bucket{channel="system",sink="file",le="+Inf"}`: `2`,
+		`goutil_log_sink_write_seconds_count{channel="system",sink="file"}`: `2`,
+		`goutil_log_sink_write_seconds_count{channel="system",sink="writer0"}`: `1`,
+	} {
+		if got, ok := samples[series]; !ok {
+			t.Errorf(`%s missing`, series)
+		} else if got != want {
+			t.Errorf(`%s = %s, want %s`, series, got, want)
+		}
+	}
+
+	sum, err := strconv.ParseFloat(samples[`goutil_log_channel_write_seconds_sum{channel="system"}`], 64)
+
+	if err != nil || sum < 0.02 {
+		t.Errorf(`channel latency sum %v, %v, want at least 0.02`, sum, err)
+	}
+
+	// Every histogram has a bucket per bound plus +Inf, a sum and a count.
+
+	var n int
+
+	for series := range samples {
+		if strings.HasPrefix(series, `goutil_log_channel_write_seconds`) {
+			n++
+		}
+	}
+
+	if want := len(LatencyBuckets) + 3; n != want {
+		t.Errorf(`channel histogram has %d series, want %d`, n, want)
+	}
+}
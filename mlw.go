@@ -16,20 +16,59 @@ package goutil
 
 import (
 	`bufio`
+	`crypto/ed25519`
 	`encoding/json`
+	`fmt`
 	`log`
 	`io`
 	`io/ioutil`
 	`os`
 	`path/filepath`
 	`strings`
+	`sync`
+	`time`
 	`github.com/RackSec/srslog`
 )
 
+// LogChannel identifies one of the MultiLoggerWriter channels.
+type LogChannel int
+
+const (
+	SystemChannel LogChannel = iota
+	AccessChannel
+	ErrorChannel
+)
+
+// String returns the lowercase name of the channel.
+func (this LogChannel) String() string {
+	switch this {
+	case SystemChannel:
+		return `system`
+	case AccessChannel:
+		return `access`
+	case ErrorChannel:
+		return `error`
+	default:
+		return fmt.Sprintf(`channel(%d)`, int(this))
+	}
+}
+
 type MultiLoggerWriter struct {
 
 	isLocked bool
 
+	closeOnce sync.Once
+
+	stopSignals func()
+
+	removeExitHook func()
+
+	extraWriters struct {
+		System []io.Writer
+		Access []io.Writer
+		Error []io.Writer
+	}
+
 	loggers struct {
 		System *log.Logger
 		Access *log.Logger
@@ -48,10 +87,42 @@ type MultiLoggerWriter struct {
 		Error io.Writer
 	}
 
+	messages struct {
+		System *MessageWriter
+		Access *MessageWriter
+		Error *MessageWriter
+	}
+
+	metrics *LogMetrics
+
+	retention *RetentionManager
+
+	files struct {
+		System *LogFile
+		Access *LogFile
+		Error *LogFile
+	}
+
+	switches struct {
+		System *SwitchWriter
+		Access *SwitchWriter
+		Error *SwitchWriter
+	}
+
+	diskGuard *DiskGuard
+
+	failovers struct {
+		System *FailoverWriter
+		Access *FailoverWriter
+		Error *FailoverWriter
+	}
+
+	redials []*RedialWriter
+
 	syslogs struct {
-		System *srslog.Writer
-		Access *srslog.Writer
-		Error *srslog.Writer
+		System SyslogWriter
+		Access SyslogWriter
+		Error SyslogWriter
 	}
 
 	Options struct {
@@ -74,6 +145,18 @@ type MultiLoggerWriter struct {
 			Error bool
 		}
 
+		PrettyConsole struct {
+			System bool
+			Access bool
+			Error bool
+		}
+
+		Audit struct {
+			System bool
+			Access bool
+			Error bool
+		}
+
 		UseFlags struct {
 			System bool
 			Access bool
@@ -90,6 +173,10 @@ type MultiLoggerWriter struct {
 		}
 
 		RecoveryStack bool
+
+		DiskGuard bool
+
+		HandleSignals bool
 	}
 
 	Config struct {
@@ -105,6 +192,12 @@ type MultiLoggerWriter struct {
 			Error string
 		}
 
+		LogLinks struct {
+			System string
+			Access string
+			Error string
+		}
+
 		LoggerFlags struct {
 			System int
 			Access int
@@ -122,7 +215,57 @@ type MultiLoggerWriter struct {
 			Host string
 			Port string
 			Tag string
+			Socket string
+		}
+
+		Prefix struct {
+			System PrefixFormat
+			Access PrefixFormat
+			Error PrefixFormat
+		}
+
+		Escaping struct {
+			System string
+			Access string
+			Error string
+		}
+
+		Format struct {
+			System SinkFormats
+			Access SinkFormats
+			Error SinkFormats
 		}
+
+		Audit struct {
+			KeyFile string
+			SigningKeyFile string
+			CheckpointEvery int
+		}
+
+		Failover struct {
+			System []string
+			Access []string
+			Error []string
+			Retry string
+			Check string
+		}
+
+		DiskGuard struct {
+			MinFree int64
+			ResumeFree int64
+			Interval string
+			Fallback string
+			RingSize int
+		}
+
+		Retention struct {
+			System RetentionPolicy
+			Access RetentionPolicy
+			Error RetentionPolicy
+			Interval string
+		}
+
+		ExitTimeout string
 	}
 }
 
@@ -134,12 +277,8 @@ func NewMultiLoggerWriter(cf ...string) *MultiLoggerWriter {
 		return this
 	}
 
-	if fh, err := os.Open(cf[0]); err == nil {
-		defer fh.Close()
-		jd := json.NewDecoder(fh)
-		err = jd.Decode(&this)
-	} else {
-		log.Printf("Error opening %q. Using default object.", cf[0])
+	if err := this.LoadConfig(cf[0]); err != nil {
+		log.Printf("Error loading %q: %v. Using default object.", cf[0], err)
 	}
 
 	return this
@@ -170,164 +309,947 @@ func (this *MultiLoggerWriter) Init() *MultiLoggerWriter {
 		this.Config.LogDir = filepath.Join(this.Config.AppDir, this.Config.LogDir)
 	}
 
-	var newfl = func(f string) (h *os.File, err error) {
+	// LogDir and LogFiles may contain templates such as {{.AppName}},
+	// {{.Host}}, {{.PID}} and {{.Date}}; see LogFileData.
+
+	var newfl = func(f, l string) (h *LogFile, err error) {
 
-		if h, err = os.OpenFile(f, FileFlagsAppend, FileModeDefault); err != nil {
-			log.Printf(`%v`, ErrorDecorator(err))
+		if h, err = NewLogFile(this.Config.LogDir, f, l, this.Config.AppName); err != nil {
+			reportError(ErrorDecorator(err))
 		}
 
 		return h, err
 	}
 
-	var newsl = func(p srslog.Priority) (s *srslog.Writer, err error) {
+	// The local syslog daemon is reached through a unix socket, found
+	// automatically unless Config.Syslog.Socket is set, and Host and Port
+	// are not used; CheckConfig reports them. Other protocols are network
+	// destinations.
+
+	var newsl = func(p srslog.Priority) (s SyslogWriter, err error) {
+
+		switch slProt {
+		case ``, `local`, `unix`, `unixgram`:
+			var ls *LocalSyslogWriter
+			if ls, err = NewLocalSyslogWriter(slProt, this.Config.Syslog.Socket, p, slTag); err == nil {
+				s = ls
+			}
+		default:
+			var ns *srslog.Writer
+			if ns, err = srslog.Dial(slProt, slRaddr, p, slTag); err == nil {
+				s = ns
+			}
+		}
 
-		if s, err = srslog.Dial(slProt, slRaddr, p, slTag); err != nil {
-			log.Printf(`%v`, ErrorDecorator(err))
+		if err != nil {
+			reportError(ErrorDecorator(err))
 		}
 
 		return s, err
 	}
 
+	// Wrap each sink so its writes are counted; see GetMetrics.
+
+	this.metrics = NewLogMetrics()
+
+	var meter = func(c LogChannel, sink string, w io.Writer) io.Writer {
+		return this.metrics.SinkWriter(c.String(), sink, w)
+	}
 
 	if this.Options.LogFiles.System {
-		if f, err := newfl(this.Config.LogFiles.System); err == nil {
-			sw = append(sw, f)
+		if f, err := newfl(this.Config.LogFiles.System, this.Config.LogLinks.System); err == nil {
+			this.files.System = f
+			this.switches.System = NewSwitchWriter(f)
+			sw = append(sw, this.sinkWriter(SystemChannel, `file`, this.switches.System))
 		}
 	}
 
 	if this.Options.LogFiles.Access {
-		if f, err := newfl(this.Config.LogFiles.Access); err == nil {
-			aw = append(aw, f)
+		if f, err := newfl(this.Config.LogFiles.Access, this.Config.LogLinks.Access); err == nil {
+			this.files.Access = f
+			this.switches.Access = NewSwitchWriter(f)
+			aw = append(aw, this.sinkWriter(AccessChannel, `file`, this.switches.Access))
 		}
 	}
 
 	if this.Options.LogFiles.Error {
-		if f, err := newfl(this.Config.LogFiles.Error); err == nil {
-			ew = append(ew, f)
+		if f, err := newfl(this.Config.LogFiles.Error, this.Config.LogLinks.Error); err == nil {
+			this.files.Error = f
+			this.switches.Error = NewSwitchWriter(f)
+			ew = append(ew, this.sinkWriter(ErrorChannel, `file`, this.switches.Error))
 		}
 	}
 
 	if this.Options.Console.System {
-		sw = append(sw, os.Stdout)
+		if this.Options.PrettyConsole.System {
+			sw = append(sw, this.sinkWriter(SystemChannel, `console`,
+				NewConsoleWriter(os.Stdout, SystemChannel, this.Config.LogTags.System)))
+		} else {
+			sw = append(sw, this.sinkWriter(SystemChannel, `console`, newStdioFile(os.Stdout)))
+		}
 	}
 
 	if this.Options.Console.Access {
-		aw = append(aw, os.Stdout)
+		if this.Options.PrettyConsole.Access {
+			aw = append(aw, this.sinkWriter(AccessChannel, `console`,
+				NewConsoleWriter(os.Stdout, AccessChannel, this.Config.LogTags.Access)))
+		} else {
+			aw = append(aw, this.sinkWriter(AccessChannel, `console`, newStdioFile(os.Stdout)))
+		}
 	}
 
 	if this.Options.Console.Error {
-		ew = append(ew, os.Stderr)
+		if this.Options.PrettyConsole.Error {
+			ew = append(ew, this.sinkWriter(ErrorChannel, `console`,
+				NewConsoleWriter(os.Stderr, ErrorChannel, this.Config.LogTags.Error)))
+		} else {
+			ew = append(ew, this.sinkWriter(ErrorChannel, `console`, newStdioFile(os.Stderr)))
+		}
 	}
 
 	if this.Options.Syslog.System {
 		if s, err := newsl(SyslogPriInfo); err == nil {
-			sw = append(sw, s)
+			this.syslogs.System = s
+			sw = append(sw, this.sinkWriter(SystemChannel, `syslog`, s))
 		}
 	}
 
 	if this.Options.Syslog.Access {
 		if s, err := newsl(SyslogPriInfo); err == nil {
-			aw = append(aw, s)
+			this.syslogs.Access = s
+			aw = append(aw, this.sinkWriter(AccessChannel, `syslog`, s))
 		}
 	}
 
 	if this.Options.Syslog.Error {
 		if s, err := newsl(SyslogPriErr); err == nil {
-			ew = append(ew, s)
+			this.syslogs.Error = s
+			ew = append(ew, this.sinkWriter(ErrorChannel, `syslog`, s))
 		}
 	}
 
+	// A failover chain replaces a channel's file, console and syslog
+	// sinks with an ordered list in which each message goes to the first
+	// sink that accepts it.
+
+	if chain := this.Config.Failover.System; len(chain) > 0 {
+		this.failovers.System = this.newFailoverWriter(SystemChannel, chain, newfl, newsl)
+		sw = []io.Writer{this.failovers.System}
+	}
+
+	if chain := this.Config.Failover.Access; len(chain) > 0 {
+		this.failovers.Access = this.newFailoverWriter(AccessChannel, chain, newfl, newsl)
+		aw = []io.Writer{this.failovers.Access}
+	}
+
+	if chain := this.Config.Failover.Error; len(chain) > 0 {
+		this.failovers.Error = this.newFailoverWriter(ErrorChannel, chain, newfl, newsl)
+		ew = []io.Writer{this.failovers.Error}
+	}
+
+	for i, w := range this.extraWriters.System {
+		sw = append(sw, meter(SystemChannel, fmt.Sprintf(`writer%d`, i), w))
+	}
+
+	for i, w := range this.extraWriters.Access {
+		aw = append(aw, meter(AccessChannel, fmt.Sprintf(`writer%d`, i), w))
+	}
+
+	for i, w := range this.extraWriters.Error {
+		ew = append(ew, meter(ErrorChannel, fmt.Sprintf(`writer%d`, i), w))
+	}
+
 	if len(sw) == 0 {
 		sw = append(sw, ioutil.Discard)
 	}
 
-	if len(aw) == 0 {
-		aw = append(aw, ioutil.Discard)
+	if len(aw) == 0 {
+		aw = append(aw, ioutil.Discard)
+	}
+
+	if len(ew) == 0 {
+		ew = append(ew, ioutil.Discard)
+	}
+
+	// Configure log flag options.
+
+	lFlags = this.loggerFlags()
+
+	this.Config.LoggerFlags.System = 0
+	this.Config.LoggerFlags.Access = 0
+	this.Config.LoggerFlags.Error = 0
+
+	if this.Options.UseFlags.System {
+		this.Config.LoggerFlags.System = lFlags
+	}
+
+	if this.Options.UseFlags.Access {
+		this.Config.LoggerFlags.Access = lFlags
+	}
+
+	if this.Options.UseFlags.Error {
+		this.Config.LoggerFlags.Error = lFlags
+	}
+
+	// Create io.Writers. A channel whose sinks use different formats
+	// passes records to its sinks rather than rendered bytes.
+
+	this.writers.System = this.newChannelWriter(SystemChannel, sw)
+	this.writers.Access = this.newChannelWriter(AccessChannel, aw)
+	this.writers.Error = this.newChannelWriter(ErrorChannel, ew)
+
+	// Audit channels turn each line into a hash-chained record. A channel
+	// whose keys or existing chain cannot be loaded is disabled rather
+	// than written without the protection it was configured with.
+
+	var audit = func(c LogChannel, w io.Writer, f *LogFile) io.Writer {
+
+		aw, err := this.newAuditWriter(w, f)
+
+		if err != nil {
+			reportError(ErrorDecorator(fmt.Errorf(`%s audit channel disabled: %v`, c, err)))
+			return ioutil.Discard
+		}
+
+		return aw
+	}
+
+	if this.Options.Audit.System {
+		this.writers.System = audit(SystemChannel, this.writers.System, this.files.System)
+	}
+
+	if this.Options.Audit.Access {
+		this.writers.Access = audit(AccessChannel, this.writers.Access, this.files.Access)
+	}
+
+	if this.Options.Audit.Error {
+		this.writers.Error = audit(ErrorChannel, this.writers.Error, this.files.Error)
+	}
+
+	// Escape embedded newlines and control characters so that a message
+	// cannot forge additional lines in any sink. A channel in record mode
+	// escapes each record's message in its sinks' formatters instead.
+
+	this.writers.System = this.newEscapeWriter(SystemChannel, this.writers.System)
+	this.writers.Access = this.newEscapeWriter(AccessChannel, this.writers.Access)
+	this.writers.Error = this.newEscapeWriter(ErrorChannel, this.writers.Error)
+
+	this.writers.System = this.metrics.ChannelWriter(SystemChannel.String(), this.writers.System)
+	this.writers.Access = this.metrics.ChannelWriter(AccessChannel.String(), this.writers.Access)
+	this.writers.Error = this.metrics.ChannelWriter(ErrorChannel.String(), this.writers.Error)
+
+	// Create bufio.Writers. On an escaped channel each buffered line is
+	// passed on as a message of its own, since a flush may carry several
+	// lines or part of one.
+
+	this.bufWriters.System = this.newBufWriter(this.writers.System, this.Config.Escaping.System, &this.messages.System)
+	this.bufWriters.Access = this.newBufWriter(this.writers.Access, this.Config.Escaping.Access, &this.messages.Access)
+	this.bufWriters.Error = this.newBufWriter(this.writers.Error, this.Config.Escaping.Error, &this.messages.Error)
+
+	// Create log.Loggers
+
+	this.Config.LogTags.System = strings.TrimSpace(this.Config.LogTags.System) + ` `
+	this.Config.LogTags.Access = strings.TrimSpace(this.Config.LogTags.Access) + ` `
+	this.Config.LogTags.Error = strings.TrimSpace(this.Config.LogTags.Error) + ` `
+
+	if this.recordMode(SystemChannel) {
+		this.loggers.System = log.New(this.writers.System, ``, this.Config.LoggerFlags.System & recordFileFlags)
+	} else {
+		this.loggers.System = this.newLogger(
+			this.writers.System,
+			this.Config.LogTags.System,
+			this.Config.LoggerFlags.System,
+			this.Config.Prefix.System,
+		)
+	}
+
+	if this.recordMode(AccessChannel) {
+		this.loggers.Access = log.New(this.writers.Access, ``, this.Config.LoggerFlags.Access & recordFileFlags)
+	} else {
+		this.loggers.Access = this.newLogger(
+			this.writers.Access,
+			this.Config.LogTags.Access,
+			this.Config.LoggerFlags.Access,
+			this.Config.Prefix.Access,
+		)
+	}
+
+	if this.recordMode(ErrorChannel) {
+		this.loggers.Error = log.New(this.writers.Error, ``, this.Config.LoggerFlags.Error & recordFileFlags)
+	} else {
+		this.loggers.Error = this.newLogger(
+			this.writers.Error,
+			this.Config.LogTags.Error,
+			this.Config.LoggerFlags.Error,
+			this.Config.Prefix.Error,
+		)
+	}
+
+	// Start removing old log files if a retention policy is configured.
+
+	this.initRetention()
+
+	// Start watching free space in the log directory if enabled.
+
+	if this.Options.DiskGuard {
+		this.initDiskGuard(newsl)
+	}
+
+	// Flush and close the channels when the process exits through Exit,
+	// Fatal or a handled signal.
+
+	this.removeExitHook = OnExit(this.Close)
+
+	if this.Options.HandleSignals {
+		this.stopSignals = HandleSignals(this.exitTimeout())
+	}
+
+	return this
+}
+
+// exitTimeout returns the time allowed for exit hooks to finish.
+func (this *MultiLoggerWriter) exitTimeout() time.Duration {
+
+	timeout, err := ParseAge(this.Config.ExitTimeout)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+	}
+
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return timeout
+}
+
+// Flush writes any output held by the buffered writers and commits the
+// log files to stable storage.
+func (this *MultiLoggerWriter) Flush() (err error) {
+
+	for _, w := range []io.Writer{this.bufWriters.System, this.bufWriters.Access, this.bufWriters.Error} {
+		if bw, ok := w.(*bufio.Writer); ok {
+			if e := bw.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	for _, mw := range []*MessageWriter{this.messages.System, this.messages.Access, this.messages.Error} {
+		if mw != nil {
+			if e := mw.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	for _, f := range []*LogFile{this.files.System, this.files.Access, this.files.Error} {
+		if f != nil {
+			if e := f.Sync(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
+
+// Close flushes the channels, stops background tasks and closes the log
+// files and syslog connections. Init registers it as an exit hook, which
+// it removes. It only takes effect once.
+func (this *MultiLoggerWriter) Close() (err error) {
+
+	this.closeOnce.Do(func() {
+
+		err = this.Flush()
+
+		if this.removeExitHook != nil {
+			this.removeExitHook()
+		}
+
+		if this.stopSignals != nil {
+			this.stopSignals()
+		}
+
+		if this.diskGuard != nil {
+			this.diskGuard.Stop()
+		}
+
+		if this.retention != nil {
+			this.retention.Stop()
+		}
+
+		for _, fw := range []*FailoverWriter{this.failovers.System, this.failovers.Access, this.failovers.Error} {
+			if fw != nil {
+				fw.Stop()
+			}
+		}
+
+		var closers []io.Closer
+
+		for _, rw := range this.redials {
+			closers = append(closers, rw)
+		}
+
+		for _, s := range []SyslogWriter{this.syslogs.System, this.syslogs.Access, this.syslogs.Error} {
+			if s != nil {
+				closers = append(closers, s)
+			}
+		}
+
+		for _, f := range []*LogFile{this.files.System, this.files.Access, this.files.Error} {
+			if f != nil {
+				closers = append(closers, f)
+			}
+		}
+
+		for _, c := range closers {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// Fatal writes to the error channel as log.Print does, then flushes and
+// closes every channel and runs the other exit hooks before exiting with
+// status 1. Unlike GetErrorLogger().Fatal, the message is not lost in a
+// buffer.
+func (this *MultiLoggerWriter) Fatal(v ...interface{}) {
+	this.loggers.Error.Output(2, fmt.Sprint(v...))
+	Exit(1, this.exitTimeout())
+}
+
+// Fatalf is Fatal with formatting as log.Printf does.
+func (this *MultiLoggerWriter) Fatalf(format string, v ...interface{}) {
+	this.loggers.Error.Output(2, fmt.Sprintf(format, v...))
+	Exit(1, this.exitTimeout())
+}
+
+// Panic writes to the error channel as log.Print does, flushes every
+// channel and then panics with the message. The channels are left open in
+// case the panic is recovered.
+func (this *MultiLoggerWriter) Panic(v ...interface{}) {
+	s := fmt.Sprint(v...)
+	this.loggers.Error.Output(2, s)
+	this.Flush()
+	panic(s)
+}
+
+// Panicf is Panic with formatting as log.Printf does.
+func (this *MultiLoggerWriter) Panicf(format string, v ...interface{}) {
+	s := fmt.Sprintf(format, v...)
+	this.loggers.Error.Output(2, s)
+	this.Flush()
+	panic(s)
+}
+
+// loggerFlags converts the LoggerFlags options to log package flags.
+func (this *MultiLoggerWriter) loggerFlags() (lFlags int) {
+
+	if this.Options.LoggerFlags.Standard {
+		lFlags = log.LstdFlags
+	}
+
+	if this.Options.LoggerFlags.UTC {
+		lFlags |= log.LUTC
+	}
+
+	if this.Options.LoggerFlags.Date {
+		lFlags |= log.Ldate
+	}
+
+	if this.Options.LoggerFlags.Time {
+		lFlags |= log.Ltime
+	}
+
+	if this.Options.LoggerFlags.ShortFile {
+		lFlags |= log.Lshortfile
+	}
+
+	if this.Options.LoggerFlags.LongFile {
+		lFlags |= log.Llongfile
+	}
+
+	return lFlags
+}
+
+// escaping returns the escaping policy of a channel.
+func (this *MultiLoggerWriter) escaping(c LogChannel) string {
+	switch c {
+	case AccessChannel:
+		return this.Config.Escaping.Access
+	case ErrorChannel:
+		return this.Config.Escaping.Error
+	default:
+		return this.Config.Escaping.System
+	}
+}
+
+// escapeFormat applies a channel's escaping policy to the messages of the
+// records that f formats. JSON, which encodes the message itself, is left
+// unescaped.
+func (this *MultiLoggerWriter) escapeFormat(c LogChannel, f RecordFormatter) RecordFormatter {
+
+	policy := this.escaping(c)
+
+	if _, ok := f.(JSONFormatter); ok || policy == `` || policy == EscapeNone {
+		return f
+	}
+
+	return escapeFormatter{f, policy}
+}
+
+// sinkFormat returns the format of a channel's sink.
+func (this *MultiLoggerWriter) sinkFormat(c LogChannel, sink string) string {
+	return this.sinkFormats(c).Get(sink)
+}
+
+// sinkFormats returns the sink formats of a channel.
+func (this *MultiLoggerWriter) sinkFormats(c LogChannel) SinkFormats {
+	switch c {
+	case AccessChannel:
+		return this.Config.Format.Access
+	case ErrorChannel:
+		return this.Config.Format.Error
+	default:
+		return this.Config.Format.System
+	}
+}
+
+// recordMode reports whether a channel passes records to its sinks. It
+// does not when every sink uses the text format, or when the channel is
+// audited, since audit records are built from the rendered bytes.
+func (this *MultiLoggerWriter) recordMode(c LogChannel) bool {
+
+	if this.sinkFormats(c).IsText() {
+		return false
+	}
+
+	switch c {
+	case AccessChannel:
+		return !this.Options.Audit.Access
+	case ErrorChannel:
+		return !this.Options.Audit.Error
+	default:
+		return !this.Options.Audit.System
+	}
+}
+
+// sinkWriter wraps a file, console or syslog sink so that its writes are
+// counted and, if the channel is in record mode, so that it renders
+// records in its configured format.
+func (this *MultiLoggerWriter) sinkWriter(c LogChannel, sink string, w io.Writer) io.Writer {
+
+	name := this.sinkFormat(c, sink)
+
+	if s, ok := w.(SyslogWriter); ok && name == FormatRFC5424 && this.recordMode(c) {
+		s.SetFormatter(rawSyslogFormatter)
+	}
+
+	w = this.metrics.SinkWriter(c.String(), sink, w)
+
+	if name == `` || name == FormatText || !this.recordMode(c) {
+		return w
+	}
+
+	f, err := NewRecordFormatter(name)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return w
+	}
+
+	return NewFormatWriter(w, this.escapeFormat(c, f))
+}
+
+// newChannelWriter combines the sinks of a channel.
+func (this *MultiLoggerWriter) newChannelWriter(c LogChannel, sinks []io.Writer) io.Writer {
+
+	if !this.recordMode(c) {
+		return io.MultiWriter(sinks...)
+	}
+
+	var (
+		tag string
+		flags int
+		pf PrefixFormat
+	)
+
+	switch c {
+	case SystemChannel:
+		tag, flags, pf = this.Config.LogTags.System, this.Config.LoggerFlags.System, this.Config.Prefix.System
+	case AccessChannel:
+		tag, flags, pf = this.Config.LogTags.Access, this.Config.LoggerFlags.Access, this.Config.Prefix.Access
+	case ErrorChannel:
+		tag, flags, pf = this.Config.LogTags.Error, this.Config.LoggerFlags.Error, this.Config.Prefix.Error
+	}
+
+	tag = strings.TrimSpace(tag) + ` `
+
+	text, err := NewTextFormatter(tag, this.Config.AppName, flags, pf)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return io.MultiWriter(sinks...)
+	}
+
+	return NewRecordWriter(c, tag, this.Config.AppName, flags & recordFileFlags != 0, this.escapeFormat(c, text), sinks...)
+}
+
+// rawSyslogFormatter sends messages that are already formatted as RFC
+// 5424 to syslog unchanged.
+func rawSyslogFormatter(p srslog.Priority, hostname, tag, content string) string {
+	return content
+}
+
+// newLogger creates a channel logger. If the prefix format is set, the
+// tag, the prefix fields and the date and time selected by the flags are
+// written, in that order, by a PrefixWriter rather than by the log
+// package, which would write the date and time after the fields.
+func (this *MultiLoggerWriter) newLogger(w io.Writer, tag string, flags int, pf PrefixFormat) *log.Logger {
+
+	if pf.IsZero() {
+		return log.New(w, tag, flags)
+	}
+
+	pw, err := NewPrefixWriter(w, tag, this.Config.AppName, pf.withFlags(flags))
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return log.New(w, tag, flags)
+	}
+
+	return log.New(pw, ``, flags &^ loggerTimeFlags)
+}
+
+// newBufWriter returns the buffered writer for a channel, splitting its
+// output into messages through a MessageWriter, stored in mw, if the
+// channel is escaped.
+func (this *MultiLoggerWriter) newBufWriter(w io.Writer, policy string, mw **MessageWriter) *bufio.Writer {
+
+	if policy != `` && policy != EscapeNone {
+		*mw = NewMessageWriter(w)
+		w = *mw
+	}
+
+	return bufio.NewWriter(w)
+}
+
+// newEscapeWriter wraps a channel writer in an EscapeWriter unless the
+// policy is EscapeNone or the channel is in record mode.
+func (this *MultiLoggerWriter) newEscapeWriter(c LogChannel, w io.Writer) io.Writer {
+
+	policy := this.escaping(c)
+
+	if policy == `` || policy == EscapeNone || this.recordMode(c) {
+		return w
+	}
+
+	ew, err := NewEscapeWriter(w, policy)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return w
+	}
+
+	return ew
+}
+
+// newAuditWriter wraps a channel writer in an AuditWriter using the
+// configured keys, continuing the chain in the channel's log file if it
+// has one. It fails if a configured key cannot be loaded or the existing
+// chain cannot be read.
+func (this *MultiLoggerWriter) newAuditWriter(w io.Writer, f *LogFile) (aw *AuditWriter, err error) {
+
+	var (
+		key []byte
+		signer ed25519.PrivateKey
+	)
+
+	if fn := this.Config.Audit.KeyFile; fn != `` {
+		if key, err = ReadAuditKey(fn); err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf(`audit key file %q is empty`, fn)
+		}
+	}
+
+	if fn := this.Config.Audit.SigningKeyFile; fn != `` {
+		if signer, err = ReadAuditSigningKey(fn); err != nil {
+			return nil, err
+		}
+	}
+
+	aw = NewAuditWriter(w, key, signer, this.Config.Audit.CheckpointEvery)
+
+	if f != nil {
+		if err = aw.ResumeFile(f.Name()); err != nil {
+			return nil, err
+		}
+	}
+
+	return aw, nil
+}
+
+// newFailoverWriter builds the failover chain for a channel from sink
+// names: "file", "console" and "syslog". A console sink is appended if the
+// chain lacks one so that every message lands somewhere. Syslog is dialed
+// on first use and redialed after errors.
+func (this *MultiLoggerWriter) newFailoverWriter(
+	c LogChannel,
+	chain []string,
+	newfl func(string, string) (*LogFile, error),
+	newsl func(srslog.Priority) (SyslogWriter, error),
+) *FailoverWriter {
+
+	var (
+		file, link string
+		lf **LogFile
+		sw **SwitchWriter
+		console *os.File
+		pretty bool
+		tag string
+		pri srslog.Priority
+	)
+
+	switch c {
+	case SystemChannel:
+		file, link, lf, sw = this.Config.LogFiles.System, this.Config.LogLinks.System, &this.files.System, &this.switches.System
+		console, pretty, tag, pri = os.Stdout, this.Options.PrettyConsole.System, this.Config.LogTags.System, SyslogPriInfo
+	case AccessChannel:
+		file, link, lf, sw = this.Config.LogFiles.Access, this.Config.LogLinks.Access, &this.files.Access, &this.switches.Access
+		console, pretty, tag, pri = os.Stdout, this.Options.PrettyConsole.Access, this.Config.LogTags.Access, SyslogPriInfo
+	case ErrorChannel:
+		file, link, lf, sw = this.Config.LogFiles.Error, this.Config.LogLinks.Error, &this.files.Error, &this.switches.Error
+		console, pretty, tag, pri = os.Stderr, this.Options.PrettyConsole.Error, this.Config.LogTags.Error, SyslogPriErr
+	}
+
+	retry, err := ParseAge(this.Config.Failover.Retry)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+	}
+
+	if retry <= 0 {
+		retry = 30 * time.Second
+	}
+
+	check, err := ParseAge(this.Config.Failover.Check)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+	}
+
+	fw := NewFailoverWriter(retry)
+
+	fw.OnSkip(func(sink string) {
+		this.metrics.Drop(c.String(), sink)
+	})
+
+	var hasConsole bool
+
+	for _, name := range chain {
+		if name == `console` {
+			hasConsole = true
+		}
+	}
+
+	if !hasConsole {
+		chain = append(chain, `console`)
+	}
+
+	for _, name := range chain {
+
+		var w io.Writer
+
+		switch name {
+
+		case `file`:
+
+			if *sw == nil {
+				if f, err := newfl(file, link); err == nil {
+					*lf, *sw = f, NewSwitchWriter(f)
+				}
+			}
+
+			if *sw != nil {
+				w = *sw
+			}
+
+		case `console`:
+
+			if pretty {
+				w = NewConsoleWriter(console, c, tag)
+			} else {
+				w = newStdioFile(console)
+			}
+
+		case `syslog`:
+
+			rw := NewRedialWriter(func() (io.Writer, error) {
+				s, err := newsl(pri)
+				if err != nil {
+					return nil, err
+				}
+				if this.sinkFormat(c, `syslog`) == FormatRFC5424 {
+					s.SetFormatter(rawSyslogFormatter)
+				}
+				return s, nil
+			})
+
+			this.redials = append(this.redials, rw)
+			w = rw
+
+		default:
+			reportError(ErrorDecorator(fmt.Errorf(`unknown failover sink %q`, name)))
+		}
+
+		if w != nil {
+			fw.Add(name, this.sinkWriter(c, name, w))
+		}
+	}
+
+	// Probe failed sinks so output fails back as soon as they recover.
+
+	fw.Start(check)
+
+	return fw
+}
+
+// initDiskGuard creates the disk guard for the channels' log files, using
+// newsl to dial syslog if that is the configured fallback.
+func (this *MultiLoggerWriter) initDiskGuard(newsl func(srslog.Priority) (SyslogWriter, error)) {
+
+	type guarded struct {
+		c LogChannel
+		f *LogFile
+		sw *SwitchWriter
+		console io.Writer
+		pri srslog.Priority
+	}
+
+	channels := []guarded{
+		{SystemChannel, this.files.System, this.switches.System, newStdioFile(os.Stdout), SyslogPriInfo},
+		{AccessChannel, this.files.Access, this.switches.Access, newStdioFile(os.Stdout), SyslogPriInfo},
+		{ErrorChannel, this.files.Error, this.switches.Error, newStdioFile(os.Stderr), SyslogPriErr},
+	}
+
+	var dg *DiskGuard
+
+	for _, g := range channels {
+
+		if g.f == nil {
+			continue
+		}
+
+		if dg == nil {
+			dg = NewDiskGuard(
+				uint64(this.Config.DiskGuard.MinFree),
+				uint64(this.Config.DiskGuard.ResumeFree),
+				this.loggers.Error,
+			)
+		}
+
+		var fallback io.Writer
+
+		switch this.Config.DiskGuard.Fallback {
+		case DiskFallbackSyslog:
+			if s, err := newsl(g.pri); err == nil {
+				fallback = s
+			}
+		case DiskFallbackRing:
+			rb, c := NewRingBuffer(this.Config.DiskGuard.RingSize), g.c.String()
+			rb.OnDrop(func() { this.metrics.Drop(c, `file`) })
+			fallback = rb
+		}
+
+		if fallback == nil {
+			fallback = g.console
+		}
+
+		dg.Add(g.c.String(), filepath.Dir(g.f.Name()), g.sw, fallback)
 	}
 
-	if len(ew) == 0 {
-		ew = append(ew, ioutil.Discard)
+	if dg == nil {
+		return
 	}
 
-	// Configure log flag options.
-
-	this.Config.LoggerFlags.System = 0
-	this.Config.LoggerFlags.Access = 0
-	this.Config.LoggerFlags.Error = 0
+	interval, err := ParseAge(this.Config.DiskGuard.Interval)
 
-	if this.Options.LoggerFlags.Standard {
-		lFlags = log.LstdFlags
+	if err != nil {
+		reportError(ErrorDecorator(err))
 	}
 
-	if this.Options.LoggerFlags.UTC {
-		lFlags |= log.LUTC
+	if interval <= 0 {
+		interval = time.Minute
 	}
 
-	if this.Options.LoggerFlags.Date {
-		lFlags |= log.Ldate
-	}
+	this.diskGuard = dg
+	this.diskGuard.Start(interval)
+}
 
-	if this.Options.LoggerFlags.Time {
-		lFlags |= log.Ltime
-	}
+// initRetention creates the retention manager for any channel that has
+// both a log file and a retention policy.
+func (this *MultiLoggerWriter) initRetention() {
 
-	if this.Options.LoggerFlags.ShortFile {
-		lFlags |= log.Lshortfile
+	type rule struct {
+		c LogChannel
+		f *LogFile
+		name string
+		p RetentionPolicy
 	}
 
-	if this.Options.LoggerFlags.LongFile {
-		lFlags |= log.Llongfile
+	rules := []rule{
+		{SystemChannel, this.files.System, this.Config.LogFiles.System, this.Config.Retention.System},
+		{AccessChannel, this.files.Access, this.Config.LogFiles.Access, this.Config.Retention.Access},
+		{ErrorChannel, this.files.Error, this.Config.LogFiles.Error, this.Config.Retention.Error},
 	}
 
-	if this.Options.UseFlags.System {
-		this.Config.LoggerFlags.System = lFlags
-	}
+	rm := NewRetentionManager(this.loggers.System)
 
-	if this.Options.UseFlags.Access {
-		this.Config.LoggerFlags.Access = lFlags
-	}
+	var n int
 
-	if this.Options.UseFlags.Error {
-		this.Config.LoggerFlags.Error = lFlags
-	}
+	for _, r := range rules {
 
-	// Create io.Writers
+		if r.f == nil || r.p.IsZero() {
+			continue
+		}
 
-	this.writers.System = io.MultiWriter(sw...)
-	this.writers.Access = io.MultiWriter(aw...)
-	this.writers.Error = io.MultiWriter(ew...)
+		pattern := RetentionPattern(this.Config.LogDir, r.name)
+		match, err := RetentionRegexp(this.Config.LogDir, r.name, LogFileData{AppName: r.f.data.AppName, Host: r.f.data.Host})
 
-	// Create bufio.Writers
+		if err == nil {
+			err = rm.AddRule(r.c.String(), pattern, match, r.p, r.f.Name)
+		}
 
-	this.bufWriters.System = bufio.NewWriter(this.writers.System)
-	this.bufWriters.Access = bufio.NewWriter(this.writers.Access)
-	this.bufWriters.Error = bufio.NewWriter(this.writers.Error)
+		if err != nil {
+			reportError(ErrorDecorator(err))
+			continue
+		}
 
-	// Create log.Loggers
+		n++
+	}
 
-	this.Config.LogTags.System = strings.TrimSpace(this.Config.LogTags.System) + ` `
-	this.Config.LogTags.Access = strings.TrimSpace(this.Config.LogTags.Access) + ` `
-	this.Config.LogTags.Error = strings.TrimSpace(this.Config.LogTags.Error) + ` `
+	if n == 0 {
+		return
+	}
 
-	this.loggers.System = log.New(
-		this.writers.System,
-		this.Config.LogTags.System,
-		this.Config.LoggerFlags.System,
-	)
+	interval, err := ParseAge(this.Config.Retention.Interval)
 
-	this.loggers.Access = log.New(
-		this.writers.Access,
-		this.Config.LogTags.Access,
-		this.Config.LoggerFlags.Access,
-	)
+	if err != nil {
+		reportError(ErrorDecorator(err))
+	}
 
-	this.loggers.Error = log.New(
-		this.writers.Error,
-		this.Config.LogTags.Error,
-		this.Config.LoggerFlags.Error,
-	)
+	if interval <= 0 {
+		interval = time.Hour
+	}
 
-	return this
+	this.retention = rm
+	this.retention.Start(interval)
 }
 
 func (this *MultiLoggerWriter) GetConfig() (b []byte, err error) {
@@ -366,6 +1288,51 @@ func (this *MultiLoggerWriter) GetErrorWriter() io.Writer {
 	return this.writers.Error
 }
 
+func (this *MultiLoggerWriter) GetWriter(c LogChannel) io.Writer {
+	switch c {
+	case AccessChannel:
+		return this.writers.Access
+	case ErrorChannel:
+		return this.writers.Error
+	default:
+		return this.writers.System
+	}
+}
+
+// GetMetrics returns the line, byte, error, drop and latency counters for
+// each channel and sink. Writers added with AddWriter are the sinks
+// "writer0", "writer1" and so on, in the order they were added. The
+// returned object is an http.Handler that serves the counters in the
+// Prometheus text format.
+func (this *MultiLoggerWriter) GetMetrics() *LogMetrics {
+	return this.metrics
+}
+
+// GetRetention returns the retention manager, or nil if no channel has a
+// retention policy.
+func (this *MultiLoggerWriter) GetRetention() *RetentionManager {
+	return this.retention
+}
+
+// GetFailover returns the failover chain of a channel, or nil if the
+// channel does not have one. Its Active and Status methods report which
+// sink is receiving output.
+func (this *MultiLoggerWriter) GetFailover(c LogChannel) *FailoverWriter {
+	switch c {
+	case AccessChannel:
+		return this.failovers.Access
+	case ErrorChannel:
+		return this.failovers.Error
+	default:
+		return this.failovers.System
+	}
+}
+
+// GetDiskGuard returns the disk guard, or nil if it is not enabled.
+func (this *MultiLoggerWriter) GetDiskGuard() *DiskGuard {
+	return this.diskGuard
+}
+
 // Getters for BufWriters.
 
 func (this *MultiLoggerWriter) GetSystemBufWriter() io.Writer {
@@ -394,8 +1361,34 @@ func (this *MultiLoggerWriter) GetErrorLogger() *log.Logger {
 	return this.loggers.Error
 }
 
+func (this *MultiLoggerWriter) GetLogger(c LogChannel) *log.Logger {
+	switch c {
+	case AccessChannel:
+		return this.loggers.Access
+	case ErrorChannel:
+		return this.loggers.Error
+	default:
+		return this.loggers.System
+	}
+}
+
 // Setters.
 
+// AddWriter attaches an additional writer to a channel. The writer
+// receives the same output as the channel's files, console and syslog.
+func (this *MultiLoggerWriter) AddWriter(c LogChannel, w io.Writer) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	switch c {
+	case SystemChannel:
+		this.extraWriters.System = append(this.extraWriters.System, w)
+	case AccessChannel:
+		this.extraWriters.Access = append(this.extraWriters.Access, w)
+	case ErrorChannel:
+		this.extraWriters.Error = append(this.extraWriters.Error, w)
+	}
+	return this
+}
+
 func (this *MultiLoggerWriter) EnableSystem(b bool) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Options.LogFiles.System = b
@@ -444,6 +1437,50 @@ func (this *MultiLoggerWriter) EnableSyslog(b bool) *MultiLoggerWriter {
 	return this
 }
 
+func (this *MultiLoggerWriter) EnablePrettyConsole(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.PrettyConsole.System = b
+	this.Options.PrettyConsole.Access = b
+	this.Options.PrettyConsole.Error = b
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemPrettyConsole(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.PrettyConsole.System = b
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessPrettyConsole(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.PrettyConsole.Access = b
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorPrettyConsole(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.PrettyConsole.Error = b
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemAudit(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.Audit.System = b
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessAudit(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.Audit.Access = b
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorAudit(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.Audit.Error = b
+	return this
+}
+
 func (this *MultiLoggerWriter) SystemUseFlags(b bool) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Options.UseFlags.System = b
@@ -506,6 +1543,24 @@ func (this *MultiLoggerWriter) RecoveryStack(b bool) *MultiLoggerWriter {
 	return this
 }
 
+func (this *MultiLoggerWriter) DiskGuard(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.DiskGuard = b
+	return this
+}
+
+func (this *MultiLoggerWriter) HandleSignals(b bool) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Options.HandleSignals = b
+	return this
+}
+
+func (this *MultiLoggerWriter) ExitTimeout(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.ExitTimeout = s
+	return this
+}
+
 func (this *MultiLoggerWriter) AppName(s string) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Config.AppName = s
@@ -542,6 +1597,24 @@ func (this *MultiLoggerWriter) ErrorLog(s string) *MultiLoggerWriter {
 	return this
 }
 
+func (this *MultiLoggerWriter) SystemLink(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.LogLinks.System = s
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessLink(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.LogLinks.Access = s
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorLink(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.LogLinks.Error = s
+	return this
+}
+
 func (this *MultiLoggerWriter) SyslogProt(s string) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Config.Syslog.Prot = s
@@ -560,12 +1633,174 @@ func (this *MultiLoggerWriter) SyslogPort(s string) *MultiLoggerWriter {
 	return this
 }
 
+func (this *MultiLoggerWriter) SyslogSocket(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Syslog.Socket = s
+	return this
+}
+
 func (this *MultiLoggerWriter) SyslogTag(s string) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Config.Syslog.Tag = s
 	return this
 }
 
+func (this *MultiLoggerWriter) SystemPrefix(pf PrefixFormat) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Prefix.System = pf
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessPrefix(pf PrefixFormat) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Prefix.Access = pf
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorPrefix(pf PrefixFormat) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Prefix.Error = pf
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemEscaping(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Escaping.System = s
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessEscaping(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Escaping.Access = s
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorEscaping(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Escaping.Error = s
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemFormat(f SinkFormats) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Format.System = f
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessFormat(f SinkFormats) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Format.Access = f
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorFormat(f SinkFormats) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Format.Error = f
+	return this
+}
+
+func (this *MultiLoggerWriter) AuditKeyFile(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Audit.KeyFile = s
+	return this
+}
+
+func (this *MultiLoggerWriter) AuditSigningKeyFile(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Audit.SigningKeyFile = s
+	return this
+}
+
+func (this *MultiLoggerWriter) AuditCheckpointEvery(n int) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Audit.CheckpointEvery = n
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemFailover(chain ...string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Failover.System = chain
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessFailover(chain ...string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Failover.Access = chain
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorFailover(chain ...string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Failover.Error = chain
+	return this
+}
+
+func (this *MultiLoggerWriter) FailoverRetry(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Failover.Retry = s
+	return this
+}
+
+func (this *MultiLoggerWriter) FailoverCheck(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Failover.Check = s
+	return this
+}
+
+func (this *MultiLoggerWriter) DiskGuardMinFree(n int64) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.DiskGuard.MinFree = n
+	return this
+}
+
+func (this *MultiLoggerWriter) DiskGuardResumeFree(n int64) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.DiskGuard.ResumeFree = n
+	return this
+}
+
+func (this *MultiLoggerWriter) DiskGuardInterval(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.DiskGuard.Interval = s
+	return this
+}
+
+func (this *MultiLoggerWriter) DiskGuardFallback(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.DiskGuard.Fallback = s
+	return this
+}
+
+func (this *MultiLoggerWriter) DiskGuardRingSize(n int) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.DiskGuard.RingSize = n
+	return this
+}
+
+func (this *MultiLoggerWriter) SystemRetention(p RetentionPolicy) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Retention.System = p
+	return this
+}
+
+func (this *MultiLoggerWriter) AccessRetention(p RetentionPolicy) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Retention.Access = p
+	return this
+}
+
+func (this *MultiLoggerWriter) ErrorRetention(p RetentionPolicy) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Retention.Error = p
+	return this
+}
+
+func (this *MultiLoggerWriter) RetentionInterval(s string) *MultiLoggerWriter {
+	if this.isLocked {panic(`configuration is locked`)}
+	this.Config.Retention.Interval = s
+	return this
+}
+
 func (this *MultiLoggerWriter) SystemTag(s string) *MultiLoggerWriter {
 	if this.isLocked {panic(`configuration is locked`)}
 	this.Config.LogTags.System = s
@@ -600,6 +1835,11 @@ func (this *MultiLoggerWriter) Defaults() *MultiLoggerWriter {
 
 		EnableConsole(false).
 		EnableSyslog(false).
+		EnablePrettyConsole(false).
+
+		SystemAudit(false).
+		AccessAudit(false).
+		ErrorAudit(false).
 
 		FlagsUTC(false).
 		FlagsDate(false).
@@ -610,6 +1850,10 @@ func (this *MultiLoggerWriter) Defaults() *MultiLoggerWriter {
 
 		RecoveryStack(false).
 
+		DiskGuard(false).
+
+		HandleSignals(false).
+
 		AppName(``).
 
 		AppDir(``).
@@ -619,14 +1863,54 @@ func (this *MultiLoggerWriter) Defaults() *MultiLoggerWriter {
 		AccessLog(`access.log`).
 		ErrorLog(`error.log`).
 
+		SystemLink(`system.log`).
+		AccessLink(`access.log`).
+		ErrorLink(`error.log`).
+
 		SyslogProt(``).
 		SyslogHost(``).
 		SyslogPort(``).
 		SyslogTag(``).
+		SyslogSocket(``).
 
 		SystemTag(`system`).
 		AccessTag(`access`).
-		ErrorTag(`error`)
+		ErrorTag(`error`).
+
+		SystemPrefix(PrefixFormat{}).
+		AccessPrefix(PrefixFormat{}).
+		ErrorPrefix(PrefixFormat{}).
+
+		SystemEscaping(EscapeNone).
+		AccessEscaping(EscapeNone).
+		ErrorEscaping(EscapeNone).
+
+		SystemFormat(SinkFormats{}).
+		AccessFormat(SinkFormats{}).
+		ErrorFormat(SinkFormats{}).
+
+		AuditKeyFile(``).
+		AuditSigningKeyFile(``).
+		AuditCheckpointEvery(1000).
+
+		SystemFailover().
+		AccessFailover().
+		ErrorFailover().
+		FailoverRetry(`30s`).
+		FailoverCheck(`5s`).
+
+		DiskGuardMinFree(100 << 20).
+		DiskGuardResumeFree(200 << 20).
+		DiskGuardInterval(`1m`).
+		DiskGuardFallback(DiskFallbackConsole).
+		DiskGuardRingSize(1000).
+
+		SystemRetention(RetentionPolicy{}).
+		AccessRetention(RetentionPolicy{}).
+		ErrorRetention(RetentionPolicy{}).
+		RetentionInterval(`1h`).
+
+		ExitTimeout(`5s`)
 }
 
 func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
@@ -634,7 +1918,8 @@ func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
 	return this.Defaults().Init()
 }
 
-/* Sample configuration file with defaults:
+/* Sample configuration file with defaults. The current version can be
+   printed with "logcfg default"; see cmd/logcfg.
 
 {
 	"Options": {
@@ -653,6 +1938,16 @@ func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
 			"Access": false,
 			"Error": false
 		},
+		"PrettyConsole": {
+			"System": false,
+			"Access": false,
+			"Error": false
+		},
+		"Audit": {
+			"System": false,
+			"Access": false,
+			"Error": false
+		},
 		"LoggerFlags": {
 			"UTC": false,
 			"Date": false,
@@ -666,7 +1961,9 @@ func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
 			"Access": false,
 			"Error": true
 		},
-		"RecoveryStack": false
+		"RecoveryStack": false,
+		"DiskGuard": false,
+		"HandleSignals": false
 	},
 	"Config": {
 		"AppName": "",
@@ -677,6 +1974,11 @@ func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
 			"Access": "access.log",
 			"Error": "error.log"
 		},
+		"LogLinks": {
+			"System": "system.log",
+			"Access": "access.log",
+			"Error": "error.log"
+		},
 		"LoggerFlags": {
 			"System": 3,
 			"Access": 0,
@@ -691,8 +1993,95 @@ func (this *MultiLoggerWriter) DefaultsInit() *MultiLoggerWriter {
 			"Prot": "",
 			"Host": "",
 			"Port": "",
-			"Tag": ""
-		}
+			"Tag": "",
+			"Socket": ""
+		},
+		"Prefix": {
+			"System": {
+				"TimeLayout": "",
+				"TimeZone": "",
+				"Host": false,
+				"PID": false,
+				"AppName": false,
+				"GoroutineID": false
+			},
+			"Access": {
+				"TimeLayout": "",
+				"TimeZone": "",
+				"Host": false,
+				"PID": false,
+				"AppName": false,
+				"GoroutineID": false
+			},
+			"Error": {
+				"TimeLayout": "",
+				"TimeZone": "",
+				"Host": false,
+				"PID": false,
+				"AppName": false,
+				"GoroutineID": false
+			}
+		},
+		"Escaping": {
+			"System": "none",
+			"Access": "none",
+			"Error": "none"
+		},
+		"Format": {
+			"System": {
+				"File": "",
+				"Console": "",
+				"Syslog": ""
+			},
+			"Access": {
+				"File": "",
+				"Console": "",
+				"Syslog": ""
+			},
+			"Error": {
+				"File": "",
+				"Console": "",
+				"Syslog": ""
+			}
+		},
+		"Audit": {
+			"KeyFile": "",
+			"SigningKeyFile": "",
+			"CheckpointEvery": 1000
+		},
+		"Failover": {
+			"System": [],
+			"Access": [],
+			"Error": [],
+			"Retry": "30s",
+			"Check": "5s"
+		},
+		"DiskGuard": {
+			"MinFree": 104857600,
+			"ResumeFree": 209715200,
+			"Interval": "1m",
+			"Fallback": "console",
+			"RingSize": 1000
+		},
+		"Retention": {
+			"System": {
+				"MaxAge": "",
+				"MaxFiles": 0,
+				"MaxSize": 0
+			},
+			"Access": {
+				"MaxAge": "",
+				"MaxFiles": 0,
+				"MaxSize": 0
+			},
+			"Error": {
+				"MaxAge": "",
+				"MaxFiles": 0,
+				"MaxSize": 0
+			},
+			"Interval": "1h"
+		},
+		"ExitTimeout": "5s"
 	}
 }
 */
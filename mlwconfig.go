@@ -0,0 +1,778 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`encoding/json`
+	`fmt`
+	`io`
+	`io/ioutil`
+	`os`
+	`path/filepath`
+	`reflect`
+	`strings`
+	`text/template`
+	`time`
+)
+
+// ConfigError describes a problem in a MultiLoggerWriter configuration
+// file. Path is the dotted field path, e.g. "Config.Retention.System".
+// Line and Column are one-based and zero if the location is unknown.
+type ConfigError struct {
+	Line int
+	Column int
+	Path string
+	Msg string
+}
+
+// Error implements the error interface.
+func (this *ConfigError) Error() string {
+
+	switch {
+	case this.Line > 0 && this.Path != ``:
+		return fmt.Sprintf(`%d:%d: %s: %s`, this.Line, this.Column, this.Path, this.Msg)
+	case this.Line > 0:
+		return fmt.Sprintf(`%d:%d: %s`, this.Line, this.Column, this.Msg)
+	case this.Path != ``:
+		return fmt.Sprintf(`%s: %s`, this.Path, this.Msg)
+	default:
+		return this.Msg
+	}
+}
+
+// LoadConfig decodes a JSON configuration file over the current settings,
+// so that fields absent from the file keep their values. It is typically
+// called after Defaults.
+//
+// A file may name one or more base files in a top-level "Include" key,
+// as a string or an array of strings, relative to its own directory. The
+// bases are loaded first, in order, and the file's own keys are merged
+// over them. If the environment variable named by ConfigEnvVar is set,
+// an overlay file named after it (logging.prod.json for logging.json and
+// "prod") is merged last if it exists. Objects are merged key by key;
+// arrays and other values replace those beneath them. Keys must match the
+// case of the field names to be merged.
+func (this *MultiLoggerWriter) LoadConfig(cf string) (err error) {
+
+	if this.isLocked {panic(`configuration is locked`)}
+
+	m, err := loadConfigTree(cf, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if env := os.Getenv(ConfigEnvVar); env != `` {
+
+		ov := ConfigOverlayName(cf, env)
+
+		if _, err = os.Stat(ov); err == nil {
+
+			om, err := loadConfigTree(ov, nil)
+
+			if err != nil {
+				return err
+			}
+
+			mergeConfig(m, om)
+
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(m)
+
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(b, this); err != nil {
+		return fmt.Errorf(`%s: %v`, cf, err)
+	}
+
+	return nil
+}
+
+// ConfigOverlayName returns the name of the overlay file for an
+// environment: the environment is inserted before the extension.
+func ConfigOverlayName(cf, env string) string {
+	ext := filepath.Ext(cf)
+	return strings.TrimSuffix(cf, ext) + `.` + env + ext
+}
+
+// loadConfigTree reads a configuration file and the files it includes
+// and returns their merged contents. The stack holds the absolute paths
+// of the including files and is used to detect cycles.
+func loadConfigTree(fn string, stack []string) (m map[string]interface{}, err error) {
+
+	abs, err := filepath.Abs(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range stack {
+		if s == abs {
+			return nil, fmt.Errorf(`include cycle: %s`, strings.Join(append(stack, abs), ` -> `))
+		}
+	}
+
+	b, err := ioutil.ReadFile(fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if err = dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf(`%s: %v`, fn, err)
+	}
+
+	var includes []string
+
+	switch v := m[ConfigIncludeKey].(type) {
+	case nil:
+	case string:
+		includes = []string{v}
+	case []interface{}:
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf(`%s: %s: expected string, found %v`, fn, ConfigIncludeKey, e)
+			}
+			includes = append(includes, s)
+		}
+	default:
+		return nil, fmt.Errorf(`%s: %s: expected string or array of strings`, fn, ConfigIncludeKey)
+	}
+
+	delete(m, ConfigIncludeKey)
+
+	base := make(map[string]interface{})
+
+	for _, inc := range includes {
+
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(fn), inc)
+		}
+
+		im, err := loadConfigTree(inc, append(stack, abs))
+
+		if err != nil {
+			return nil, fmt.Errorf(`%s: %v`, fn, err)
+		}
+
+		mergeConfig(base, im)
+	}
+
+	mergeConfig(base, m)
+
+	return base, nil
+}
+
+// mergeConfig merges src into dst, recursing into objects present in
+// both.
+func mergeConfig(dst, src map[string]interface{}) {
+
+	for k, v := range src {
+
+		sm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+
+		if ok && dok {
+			mergeConfig(dm, sm)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+// CheckConfig reports configuration values that are well-formed JSON but
+// would be rejected or ignored by Init.
+func (this *MultiLoggerWriter) CheckConfig() (errs []*ConfigError) {
+
+	var add = func(path, format string, a ...interface{}) {
+		errs = append(errs, &ConfigError{Path: path, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	if this.Options.LoggerFlags.LongFile && this.Options.LoggerFlags.ShortFile {
+		add(`Options.LoggerFlags`, `LongFile and ShortFile are mutually exclusive`)
+	}
+
+	if _, err := template.New(``).Parse(this.Config.LogDir); err != nil {
+		add(`Config.LogDir`, `%v`, err)
+	}
+
+	channels := []struct {
+		name string
+		file string
+		escaping string
+		prefix PrefixFormat
+		retention RetentionPolicy
+		format SinkFormats
+		audit bool
+	}{
+		{`System`, this.Config.LogFiles.System, this.Config.Escaping.System,
+			this.Config.Prefix.System, this.Config.Retention.System,
+			this.Config.Format.System, this.Options.Audit.System},
+		{`Access`, this.Config.LogFiles.Access, this.Config.Escaping.Access,
+			this.Config.Prefix.Access, this.Config.Retention.Access,
+			this.Config.Format.Access, this.Options.Audit.Access},
+		{`Error`, this.Config.LogFiles.Error, this.Config.Escaping.Error,
+			this.Config.Prefix.Error, this.Config.Retention.Error,
+			this.Config.Format.Error, this.Options.Audit.Error},
+	}
+
+	for _, c := range channels {
+
+		if _, err := template.New(``).Parse(c.file); err != nil {
+			add(`Config.LogFiles.` + c.name, `%v`, err)
+		}
+
+		if !ValidEscaping(c.escaping) {
+			add(`Config.Escaping.` + c.name, `invalid escaping policy %q`, c.escaping)
+		}
+
+		for _, sink := range []string{`File`, `Console`, `Syslog`} {
+			if f := c.format.Get(strings.ToLower(sink)); !ValidFormat(f) {
+				add(`Config.Format.` + c.name + `.` + sink, `invalid format %q`, f)
+			}
+		}
+
+		if c.audit && !c.format.IsText() {
+			add(`Config.Format.` + c.name, `sink formats other than text are ignored on an audited channel`)
+		}
+
+		if tz := c.prefix.TimeZone; tz != `` && tz != `Local` {
+			if _, err := time.LoadLocation(tz); err != nil {
+				add(`Config.Prefix.` + c.name + `.TimeZone`, `%v`, err)
+			}
+		}
+
+		if _, err := ParseAge(c.retention.MaxAge); err != nil {
+			add(`Config.Retention.` + c.name + `.MaxAge`, `%v`, err)
+		}
+
+		if c.retention.MaxFiles < 0 {
+			add(`Config.Retention.` + c.name + `.MaxFiles`, `must not be negative`)
+		}
+
+		if c.retention.MaxSize < 0 {
+			add(`Config.Retention.` + c.name + `.MaxSize`, `must not be negative`)
+		}
+	}
+
+	if _, err := ParseAge(this.Config.Retention.Interval); err != nil {
+		add(`Config.Retention.Interval`, `%v`, err)
+	}
+
+	switch this.Config.Syslog.Prot {
+	case ``, `local`, `unix`, `unixgram`:
+		if this.Config.Syslog.Host != `` {
+			add(`Config.Syslog.Host`, `not used by local syslog protocol %q`, this.Config.Syslog.Prot)
+		}
+		if this.Config.Syslog.Port != `` {
+			add(`Config.Syslog.Port`, `not used by local syslog protocol %q`, this.Config.Syslog.Prot)
+		}
+	case `tcp`, `tcp4`, `tcp6`, `udp`, `udp4`, `udp6`, `tcp+tls`:
+	default:
+		add(`Config.Syslog.Prot`, `unsupported protocol %q`, this.Config.Syslog.Prot)
+	}
+
+	switch this.Config.DiskGuard.Fallback {
+	case ``, DiskFallbackConsole, DiskFallbackSyslog, DiskFallbackRing:
+	default:
+		add(`Config.DiskGuard.Fallback`, `unsupported fallback %q`, this.Config.DiskGuard.Fallback)
+	}
+
+	failover := map[string][]string{
+		`System`: this.Config.Failover.System,
+		`Access`: this.Config.Failover.Access,
+		`Error`: this.Config.Failover.Error,
+	}
+
+	for name, chain := range failover {
+		for _, sink := range chain {
+			switch sink {
+			case `file`, `console`, `syslog`:
+			default:
+				add(`Config.Failover.` + name, `unknown sink %q`, sink)
+			}
+		}
+	}
+
+	if _, err := ParseAge(this.Config.Failover.Retry); err != nil {
+		add(`Config.Failover.Retry`, `%v`, err)
+	}
+
+	if _, err := ParseAge(this.Config.Failover.Check); err != nil {
+		add(`Config.Failover.Check`, `%v`, err)
+	}
+
+	if _, err := ParseAge(this.Config.ExitTimeout); err != nil {
+		add(`Config.ExitTimeout`, `%v`, err)
+	}
+
+	if _, err := ParseAge(this.Config.DiskGuard.Interval); err != nil {
+		add(`Config.DiskGuard.Interval`, `%v`, err)
+	}
+
+	if this.Config.Audit.CheckpointEvery < 0 {
+		add(`Config.Audit.CheckpointEvery`, `must not be negative`)
+	}
+
+	return errs
+}
+
+// ValidateConfig checks a JSON configuration file against the structure
+// of MultiLoggerWriter and the rules of CheckConfig. Unknown fields, type
+// mismatches and syntax errors are reported with their line and column.
+func ValidateConfig(r io.Reader) (errs []*ConfigError, err error) {
+
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v := &configValidator{
+		data: data,
+		dec: json.NewDecoder(bytes.NewReader(data)),
+		offsets: make(map[string]int64),
+	}
+
+	v.dec.UseNumber()
+
+	if err = v.value(reflect.TypeOf(MultiLoggerWriter{}), ``); err != nil {
+		return append(v.errs, v.syntaxError(err)), nil
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs, nil
+	}
+
+	mlw := new(MultiLoggerWriter).Defaults()
+
+	if err = json.Unmarshal(data, mlw); err != nil {
+		return append(v.errs, &ConfigError{Msg: err.Error()}), nil
+	}
+
+	for _, ce := range mlw.CheckConfig() {
+		if off, ok := v.offsets[ce.Path]; ok {
+			ce.Line, ce.Column = v.position(off)
+		}
+		errs = append(errs, ce)
+	}
+
+	return errs, nil
+}
+
+// configValidator walks the JSON token stream alongside the Go type,
+// recording the offset of every field it visits.
+type configValidator struct {
+	data []byte
+	dec *json.Decoder
+	offsets map[string]int64
+	errs []*ConfigError
+}
+
+// value validates the next JSON value against type t.
+func (this *configValidator) value(t reflect.Type, path string) (err error) {
+
+	off := this.next()
+	this.offsets[path] = off
+
+	tok, err := this.dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	var mismatch = func(want string) error {
+		this.fail(off, path, fmt.Sprintf(`expected %s, found %s`, want, tokenKind(tok)))
+		if d, ok := tok.(json.Delim); ok && (d == '{' || d == '[') {
+			return this.skip()
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+
+	case reflect.Struct:
+
+		if d, ok := tok.(json.Delim); !ok || d != '{' {
+			return mismatch(`object`)
+		}
+
+		for this.dec.More() {
+
+			koff := this.next()
+			tok, err := this.dec.Token()
+
+			if err != nil {
+				return err
+			}
+
+			key := tok.(string)
+			f, ok := fieldByJSONName(t, key)
+			fpath := strings.TrimPrefix(path + `.` + key, `.`)
+
+			if path == `` && key == ConfigIncludeKey {
+				if err = this.include(koff, fpath); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !ok {
+				this.fail(koff, fpath, `unknown field`)
+				if err = this.skipValue(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			fpath = strings.TrimPrefix(path + `.` + f.Name, `.`)
+
+			if err = this.value(f.Type, fpath); err != nil {
+				return err
+			}
+		}
+
+		_, err = this.dec.Token()
+		return err
+
+	case reflect.Bool:
+
+		if _, ok := tok.(bool); !ok {
+			return mismatch(`boolean`)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+
+		n, ok := tok.(json.Number)
+
+		if !ok {
+			return mismatch(`integer`)
+		}
+
+		if _, err := n.Int64(); err != nil {
+			this.fail(off, path, fmt.Sprintf(`expected integer, found %s`, n))
+		}
+
+	case reflect.String:
+
+		if _, ok := tok.(string); !ok {
+			return mismatch(`string`)
+		}
+
+	case reflect.Slice:
+
+		if tok == nil {
+			return nil
+		}
+
+		if d, ok := tok.(json.Delim); !ok || d != '[' {
+			return mismatch(`array`)
+		}
+
+		for i := 0; this.dec.More(); i++ {
+			if err = this.value(t.Elem(), fmt.Sprintf(`%s[%d]`, path, i)); err != nil {
+				return err
+			}
+		}
+
+		_, err = this.dec.Token()
+		return err
+
+	default:
+
+		if d, ok := tok.(json.Delim); ok && (d == '{' || d == '[') {
+			return this.skip()
+		}
+	}
+
+	return nil
+}
+
+// include validates the value of the top-level include key, which is a
+// string or an array of strings.
+func (this *configValidator) include(off int64, path string) (err error) {
+
+	this.offsets[path] = off
+
+	tok, err := this.dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := tok.(string); ok {
+		return nil
+	}
+
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		this.fail(off, path, fmt.Sprintf(`expected string or array, found %s`, tokenKind(tok)))
+		if ok && d == '{' {
+			return this.skip()
+		}
+		return nil
+	}
+
+	for i := 0; this.dec.More(); i++ {
+
+		eoff := this.next()
+
+		if tok, err = this.dec.Token(); err != nil {
+			return err
+		}
+
+		if _, ok := tok.(string); !ok {
+			this.fail(eoff, fmt.Sprintf(`%s[%d]`, path, i), fmt.Sprintf(`expected string, found %s`, tokenKind(tok)))
+			if d, ok := tok.(json.Delim); ok && (d == '{' || d == '[') {
+				if err = this.skip(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err = this.dec.Token()
+
+	return err
+}
+
+// skipValue consumes the next complete JSON value.
+func (this *configValidator) skipValue() (err error) {
+
+	tok, err := this.dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if d, ok := tok.(json.Delim); ok && (d == '{' || d == '[') {
+		return this.skip()
+	}
+
+	return nil
+}
+
+// skip consumes tokens up to and including the delimiter closing the
+// object or array just opened.
+func (this *configValidator) skip() (err error) {
+
+	for depth := 1; depth > 0; {
+
+		tok, err := this.dec.Token()
+
+		if err != nil {
+			return err
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+
+	return nil
+}
+
+// next returns the offset at which the next token starts.
+func (this *configValidator) next() int64 {
+
+	off := this.dec.InputOffset()
+
+	for off < int64(len(this.data)) {
+		switch this.data[off] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			off++
+		default:
+			return off
+		}
+	}
+
+	return off
+}
+
+// fail records an error at the given offset.
+func (this *configValidator) fail(off int64, path, msg string) {
+	line, col := this.position(off)
+	this.errs = append(this.errs, &ConfigError{line, col, path, msg})
+}
+
+// syntaxError converts a decoder error to a ConfigError with a location.
+func (this *configValidator) syntaxError(err error) *ConfigError {
+
+	off := this.dec.InputOffset()
+
+	if se, ok := err.(*json.SyntaxError); ok {
+		off = se.Offset
+	}
+
+	line, col := this.position(off)
+
+	return &ConfigError{Line: line, Column: col, Msg: err.Error()}
+}
+
+// position converts a byte offset to a one-based line and column.
+func (this *configValidator) position(off int64) (line, col int) {
+
+	if off > int64(len(this.data)) {
+		off = int64(len(this.data))
+	}
+
+	before := this.data[:off]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(off) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+
+	return line, col
+}
+
+// fieldByJSONName finds the exported field matching a JSON key the same
+// way encoding/json does, preferring an exact match.
+func fieldByJSONName(t reflect.Type, key string) (f reflect.StructField, ok bool) {
+
+	for i := 0; i < t.NumField(); i++ {
+		if f = t.Field(i); f.PkgPath == `` && f.Name == key {
+			return f, true
+		}
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if f = t.Field(i); f.PkgPath == `` && strings.EqualFold(f.Name, key) {
+			return f, true
+		}
+	}
+
+	return f, false
+}
+
+// tokenKind describes a JSON token for error messages.
+func tokenKind(tok json.Token) string {
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return `object`
+		}
+		return `array`
+	case bool:
+		return `boolean`
+	case json.Number:
+		return `number`
+	case string:
+		return `string`
+	default:
+		return `null`
+	}
+}
+
+// ConfigSchema returns a JSON Schema describing the MultiLoggerWriter
+// configuration file, with the values set by Defaults as defaults.
+func ConfigSchema() ([]byte, error) {
+
+	defaults := reflect.ValueOf(new(MultiLoggerWriter).Defaults()).Elem()
+
+	schema := configSchema(defaults, ``)
+	schema[`$schema`] = `http://json-schema.org/draft-07/schema#`
+	schema[`title`] = `MultiLoggerWriter configuration`
+
+	// Include is read by LoadConfig rather than decoded into a field.
+
+	schema[`properties`].(map[string]interface{})[ConfigIncludeKey] = map[string]interface{}{
+		`description`: `configuration files merged beneath this one, relative to it`,
+		`oneOf`: []interface{}{
+			map[string]interface{}{`type`: `string`},
+			map[string]interface{}{`type`: `array`, `items`: map[string]interface{}{`type`: `string`}},
+		},
+	}
+
+	return json.MarshalIndent(schema, ``, "\t")
+}
+
+// configSchema builds the schema for a value, recursing into structs.
+func configSchema(v reflect.Value, path string) map[string]interface{} {
+
+	t := v.Type()
+
+	switch t.Kind() {
+
+	case reflect.Struct:
+
+		props := make(map[string]interface{})
+
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == `` {
+				props[f.Name] = configSchema(v.Field(i), strings.TrimPrefix(path + `.` + f.Name, `.`))
+			}
+		}
+
+		return map[string]interface{}{
+			`type`: `object`,
+			`properties`: props,
+			`additionalProperties`: false,
+		}
+
+	case reflect.Bool:
+		return map[string]interface{}{`type`: `boolean`, `default`: v.Bool()}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{`type`: `integer`, `default`: v.Int()}
+
+	case reflect.String:
+
+		s := map[string]interface{}{`type`: `string`, `default`: v.String()}
+
+		if strings.HasPrefix(path, `Config.Escaping.`) {
+			s[`enum`] = []string{EscapeNone, EscapeControl, EscapeQuote, EscapeContinuation}
+		}
+
+		if strings.HasPrefix(path, `Config.Format.`) {
+			s[`enum`] = []string{``, FormatText, FormatJSON, FormatRFC5424}
+		}
+
+		if path == `Config.DiskGuard.Fallback` {
+			s[`enum`] = []string{DiskFallbackConsole, DiskFallbackSyslog, DiskFallbackRing}
+		}
+
+		return s
+
+	case reflect.Slice:
+
+		items := configSchema(reflect.New(t.Elem()).Elem(), path + `[]`)
+		delete(items, `default`)
+
+		if path == `Config.Failover.System` || path == `Config.Failover.Access` || path == `Config.Failover.Error` {
+			items[`enum`] = []string{`file`, `console`, `syslog`}
+		}
+
+		// GetConfig writes an empty slice as null, which LoadConfig and
+		// ValidateConfig accept.
+
+		return map[string]interface{}{`type`: []string{`array`, `null`}, `items`: items}
+
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`encoding/json`
+	`fmt`
+	`io/ioutil`
+	`path/filepath`
+	`testing`
+)
+
+// checkSchema reports the first way in which v, decoded from JSON with
+// UseNumber, violates schema. It understands the subset of JSON Schema
+// produced by ConfigSchema.
+func checkSchema(v interface{}, schema map[string]interface{}, path string) error {
+
+	if alts, ok := schema[`oneOf`].([]interface{}); ok {
+
+		matched := 0
+
+		for _, alt := range alts {
+			if checkSchema(v, alt.(map[string]interface{}), path) == nil {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			return fmt.Errorf(`%s: matches %d of the oneOf schemas`, path, matched)
+		}
+
+		return nil
+	}
+
+	if types, ok := schema[`type`].([]interface{}); ok {
+
+		for _, typ := range types {
+
+			alt := make(map[string]interface{})
+
+			for k, sv := range schema {
+				alt[k] = sv
+			}
+
+			if alt[`type`] = typ; checkSchema(v, alt, path) == nil {
+				return nil
+			}
+		}
+
+		return fmt.Errorf(`%s: not any of %v`, path, types)
+	}
+
+	switch schema[`type`] {
+
+	case `null`:
+
+		if v != nil {
+			return fmt.Errorf(`%s: not null`, path)
+		}
+
+	case `object`:
+
+		obj, ok := v.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf(`%s: not an object`, path)
+		}
+
+		props, _ := schema[`properties`].(map[string]interface{})
+
+		for k, val := range obj {
+
+			ps, ok := props[k].(map[string]interface{})
+
+			if !ok {
+				if schema[`additionalProperties`] == false {
+					return fmt.Errorf(`%s: property %q not allowed`, path, k)
+				}
+				continue
+			}
+
+			if err := checkSchema(val, ps, path + `.` + k); err != nil {
+				return err
+			}
+		}
+
+	case `array`:
+
+		arr, ok := v.([]interface{})
+
+		if !ok {
+			return fmt.Errorf(`%s: not an array`, path)
+		}
+
+		items, _ := schema[`items`].(map[string]interface{})
+
+		for i, val := range arr {
+			if err := checkSchema(val, items, fmt.Sprintf(`%s[%d]`, path, i)); err != nil {
+				return err
+			}
+		}
+
+	case `string`:
+
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf(`%s: not a string`, path)
+		}
+
+	case `boolean`:
+
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf(`%s: not a boolean`, path)
+		}
+
+	case `integer`:
+
+		if n, ok := v.(json.Number); !ok {
+			return fmt.Errorf(`%s: not a number`, path)
+		} else if _, err := n.Int64(); err != nil {
+			return fmt.Errorf(`%s: not an integer`, path)
+		}
+	}
+
+	return nil
+}
+
+func TestConfigSchemaInclude(t *testing.T) {
+
+	b, err := ConfigSchema()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]interface{}
+
+	if err = json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		`base.json`: `{"Config": {"AppName": "base", "LogDir": "log"}}`,
+		`common.json`: `{"Include": "base.json", "Options": {"Console": {"System": true}}}`,
+		`app.json`: `{"Include": ["common.json"], "Config": {"AppName": "app"}}`,
+	}
+
+	for name, content := range files {
+
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		var v interface{}
+
+		if err = json.Unmarshal([]byte(content), &v); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = checkSchema(v, schema, name); err != nil {
+			t.Errorf(`schema rejects %s: %v`, name, err)
+		}
+	}
+
+	if err = checkSchema(map[string]interface{}{`Include`: true}, schema, `bad`); err == nil {
+		t.Error(`schema accepts a boolean Include`)
+	}
+
+	if err = checkSchema(map[string]interface{}{`Includes`: `x`}, schema, `bad`); err == nil {
+		t.Error(`schema accepts an unknown root property`)
+	}
+
+	mlw := new(MultiLoggerWriter).Defaults()
+
+	if err = mlw.LoadConfig(filepath.Join(dir, `app.json`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if mlw.Config.AppName != `app` || mlw.Config.LogDir != `log` || !mlw.Options.Console.System {
+		t.Errorf(`includes not merged: AppName %q, LogDir %q, Console.System %v`,
+			mlw.Config.AppName, mlw.Config.LogDir, mlw.Options.Console.System)
+	}
+}
+
+func TestConfigSchemaDefaults(t *testing.T) {
+
+	b, err := ConfigSchema()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]interface{}
+
+	if err = json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything Defaults sets, as GetConfig writes it, must be valid.
+
+	cfg, err := new(MultiLoggerWriter).Defaults().GetConfig()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(cfg))
+	dec.UseNumber()
+
+	if err = dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkSchema(v, schema, `defaults`); err != nil {
+		t.Errorf(`schema rejects the defaults: %v`, err)
+	}
+
+	v[`Config`].(map[string]interface{})[`AppName`] = json.Number(`5`)
+
+	if err = checkSchema(v, schema, `defaults`); err == nil {
+		t.Error(`schema accepts a numeric AppName`)
+	}
+}
@@ -16,18 +16,495 @@ package goutil
 
 import (
 	`bytes`
+	`encoding/binary`
+	`errors`
 	`fmt`
 	`io`
 	`log`
-	`path/filepath`
 	`os`
+	`strings`
+	`sync`
+	`time`
 )
 
+// WritePolicy selects how MultiWriter.Write handles a failed destination.
+type WritePolicy int
+
+const (
+	// BestEffort writes to every destination and reports all failures.
+	BestEffort WritePolicy = iota
+
+	// FailFast stops at the first destination that fails.
+	FailFast
+)
+
+var (
+	// ErrWriteTimeout is reported for a destination that did not finish
+	// a parallel write within the timeout.
+	ErrWriteTimeout = errors.New(`write timed out`)
+
+	// ErrDestinationBusy is reported for a destination that is still
+	// finishing an earlier write that timed out; the new output is
+	// dropped for it so that lines are never reordered.
+	ErrDestinationBusy = errors.New(`destination busy with an earlier write`)
+)
+
+// DestinationError describes a failed write to one destination of a
+// MultiWriter. Kind is "writer", "console" or "file"; Name is the file
+// name for consoles and files and the Go type for other writers.
+type DestinationError struct {
+	Kind  string
+	Name  string
+	Index int
+	Err   error
+}
+
+// Error implements the error interface.
+func (this *DestinationError) Error() string {
+	return fmt.Sprintf(`%s %s: %v`, this.Kind, this.Name, this.Err)
+}
+
+// Unwrap returns the underlying error.
+func (this *DestinationError) Unwrap() error {
+	return this.Err
+}
+
+// MultiWriteError lists the destinations that failed during a single
+// MultiWriter.Write. errors.Is and errors.As see each DestinationError and
+// the errors they wrap.
+type MultiWriteError struct {
+	Errors []*DestinationError
+	Total  int
+}
+
+// Error implements the error interface.
+func (this *MultiWriteError) Error() string {
+
+	msgs := make([]string, len(this.Errors))
+
+	for i, e := range this.Errors {
+		msgs[i] = e.Error()
+	}
+
+	return fmt.Sprintf(`%d of %d destinations failed: %s`,
+		len(this.Errors), this.Total, strings.Join(msgs, `; `))
+}
+
+// Unwrap returns the error of each failed destination.
+func (this *MultiWriteError) Unwrap() []error {
+
+	errs := make([]error, len(this.Errors))
+
+	for i, e := range this.Errors {
+		errs[i] = e
+	}
+
+	return errs
+}
+
+// Framing selects how each line is delimited for a destination.
+type Framing int
+
+const (
+	// FrameDefault is FrameNone for writers and FrameLF for consoles,
+	// files and network destinations.
+	FrameDefault Framing = iota
+
+	// FrameLF ends each line with a newline.
+	FrameLF
+
+	// FrameCRLF ends each line with a carriage return and a newline.
+	FrameCRLF
+
+	// FrameNUL ends each line with a NUL byte, as GELF over TCP expects.
+	FrameNUL
+
+	// FrameLength precedes each line with its length as a 4-byte
+	// big-endian unsigned integer.
+	FrameLength
+
+	// FrameNone writes each line without a terminator.
+	FrameNone
+
+	// FrameRaw writes the bytes passed to Write unchanged, line ending
+	// included. Filters still apply; transforms do not.
+	FrameRaw
+)
+
+var framingNames = []string{`default`, `lf`, `crlf`, `nul`, `length`, `none`, `raw`}
+
+// String returns the name of the framing.
+func (this Framing) String() string {
+
+	if this < 0 || int(this) >= len(framingNames) {
+		return fmt.Sprintf(`Framing(%d)`, int(this))
+	}
+
+	return framingNames[this]
+}
+
+// ParseFraming returns the framing with the given name: "lf", "crlf",
+// "nul", "length", "none" or "raw". An empty name is FrameDefault.
+func ParseFraming(name string) (Framing, error) {
+
+	if name == `` {
+		return FrameDefault, nil
+	}
+
+	for i, n := range framingNames {
+		if strings.EqualFold(n, name) {
+			return Framing(i), nil
+		}
+	}
+
+	return FrameDefault, fmt.Errorf(`unknown framing %q`, name)
+}
+
+// frame returns line delimited for a destination of the given kind. The
+// result never shares memory with line beyond its length, so line may be
+// a subslice of the caller's buffer.
+func (this Framing) frame(kind string, b, line []byte) []byte {
+
+	if this == FrameDefault {
+		if this = FrameLF; kind == DestWriter {
+			this = FrameNone
+		}
+	}
+
+	line = line[:len(line):len(line)]
+
+	switch this {
+	case FrameCRLF:
+		return append(line, '\r', '\n')
+	case FrameNUL:
+		return append(line, 0)
+	case FrameLength:
+		p := make([]byte, 4, 4 + len(line))
+		binary.BigEndian.PutUint32(p, uint32(len(line)))
+		return append(p, line...)
+	case FrameNone:
+		return line
+	case FrameRaw:
+		return b
+	}
+
+	return append(line, '\n')
+}
+
+// Destination kinds.
+const (
+	DestWriter  = `writer`
+	DestConsole = `console`
+	DestFile    = `file`
+	DestNetwork = `network`
+)
+
+// Destination is a handle to one destination of a MultiWriter, returned by
+// the Add methods. Its methods are safe to call while writes are in
+// flight: Remove and Replace wait for them to finish, including a parallel
+// write that has timed out but is still running.
+type Destination struct {
+	mw     *MultiWriter
+	mu     sync.Mutex
+	idle   *sync.Cond
+	kind   string
+	name   string
+	w      io.Writer
+	owned  bool
+	paused bool
+	busy   bool
+	filter LineFilter
+	trans  []LineTransform
+	frame  Framing
+	writes int64
+	errors int64
+	skips  int64
+	last   error
+}
+
+// DestinationInfo describes a destination of a MultiWriter.
+type DestinationInfo struct {
+	Kind      string
+	Name      string
+	Paused    bool
+	Filtered  bool
+	Framing   Framing
+	Writes    int64
+	Errors    int64
+	Skipped   int64
+	LastError string
+}
+
+// closer returns the writer as an io.Closer if the destination owns it,
+// or nil otherwise. A destination owns the files and network connections
+// the MultiWriter opens and the writers passed to Replace.
+func (this *Destination) closer() io.Closer {
+
+	if !this.owned {
+		return nil
+	}
+
+	c, _ := this.w.(io.Closer)
+
+	return c
+}
+
+// Remove detaches the destination from its MultiWriter. The writer is
+// closed if the destination owns it, as it does for files, network
+// destinations and writers passed to Replace; a console or a writer passed
+// to AddWriter is left open.
+func (this *Destination) Remove() (err error) {
+
+	this.mw.mu.Lock()
+	defer this.mw.mu.Unlock()
+
+	for i, d := range this.mw.dests {
+		if d == this {
+			this.mw.dests = append(this.mw.dests[:i:i], this.mw.dests[i+1:]...)
+			this.mu.Lock()
+			this.wait()
+			this.mu.Unlock()
+			if c := this.closer(); c != nil {
+				err = c.Close()
+			}
+			return err
+		}
+	}
+
+	return fmt.Errorf(`%s %s is not attached`, this.kind, this.name)
+}
+
+// Replace substitutes w for the destination's writer, keeping its place,
+// kind, framing and counters. The writer being replaced is closed if the
+// destination owns it. Replace takes ownership of w: if it is an io.Closer
+// it is closed when the destination is removed, replaced or closed, unless
+// it is os.Stdin, os.Stdout or os.Stderr.
+func (this *Destination) Replace(w io.Writer) (err error) {
+
+	this.mw.mu.Lock()
+	defer this.mw.mu.Unlock()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.wait()
+
+	if w == this.w {
+		return nil
+	}
+
+	if c := this.closer(); c != nil {
+		err = c.Close()
+	}
+
+	this.w, this.name = w, destName(w)
+	this.owned = w != os.Stdin && w != os.Stdout && w != os.Stderr
+
+	return err
+}
+
+// Writer returns the destination's writer.
+func (this *Destination) Writer() io.Writer {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.w
+}
+
+// Pause stops output to the destination until Resume is called.
+func (this *Destination) Pause() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.paused = true
+}
+
+// Resume restarts output to a paused destination.
+func (this *Destination) Resume() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.paused = false
+}
+
+// SetFilter sets the filter that decides which lines the destination
+// receives. A nil filter passes every line.
+func (this *Destination) SetFilter(f LineFilter) *Destination {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.filter = f
+	return this
+}
+
+// SetTransforms sets the transforms applied, in order, to each line the
+// destination receives, replacing any set before.
+func (this *Destination) SetTransforms(ts ...LineTransform) *Destination {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.trans = ts
+	return this
+}
+
+// SetFraming sets how lines written to the destination are delimited.
+func (this *Destination) SetFraming(f Framing) *Destination {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.frame = f
+	return this
+}
+
+// Info describes the destination.
+func (this *Destination) Info() (di DestinationInfo) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	di = DestinationInfo{
+		Kind:     this.kind,
+		Name:     this.name,
+		Paused:   this.paused,
+		Filtered: this.filter != nil,
+		Framing:  this.frame,
+		Writes:   this.writes,
+		Errors:   this.errors,
+		Skipped:  this.skips,
+	}
+
+	if this.last != nil {
+		di.LastError = this.last.Error()
+	}
+
+	return di
+}
+
+// writer returns the writer and the output, filtered, transformed and
+// framed, that it should receive for a write of b, whose line is b without
+// its line ending. It returns a nil writer if the destination is paused or
+// the line is filtered out.
+func (this *Destination) writer(b, line []byte) (io.Writer, []byte) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.paused {
+		return nil, nil
+	}
+
+	if this.filter != nil && !this.filter(line) {
+		this.skips++
+		return nil, nil
+	}
+
+	if this.frame != FrameRaw {
+		for _, t := range this.trans {
+			line = t(line)
+		}
+	}
+
+	return this.w, this.frame.frame(this.kind, b, line)
+}
+
+// start marks the destination busy for a parallel write. It returns false
+// if an earlier write has not finished.
+func (this *Destination) start() bool {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.busy {
+		return false
+	}
+
+	this.busy = true
+
+	return true
+}
+
+// finish marks a parallel write complete.
+func (this *Destination) finish() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.busy = false
+	this.idle.Broadcast()
+}
+
+// wait blocks until a parallel write that outlived its Write has
+// finished. The caller must hold the lock.
+func (this *Destination) wait() {
+	for this.busy {
+		this.idle.Wait()
+	}
+}
+
+// waitFor is wait with a time limit for a caller that does not hold the
+// lock. It reports whether the destination is idle.
+func (this *Destination) waitFor(limit time.Duration) bool {
+
+	this.mu.Lock()
+	busy := this.busy
+	this.mu.Unlock()
+
+	if !busy {
+		return true
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		this.mu.Lock()
+		this.wait()
+		this.mu.Unlock()
+		close(done)
+	}()
+
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// record counts a write and its outcome.
+func (this *Destination) record(err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.writes++
+
+	if err != nil {
+		this.errors++
+		this.last = err
+	}
+}
+
+// destName returns the name of a writer: the file name for files, the
+// String method's result for writers that have one, and the Go type
+// otherwise.
+func destName(w io.Writer) string {
+
+	if f, ok := w.(interface{ Name() string }); ok {
+		return f.Name()
+	}
+
+	if s, ok := w.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	return fmt.Sprintf(`%T`, w)
+}
+
 // MultiWriter is an io.Writer that sends output to multiple destinations.
+// It is safe for concurrent use. Each Write reaches every destination
+// before the next Write starts, so lines are never interleaved and appear
+// in the same order everywhere.
 type MultiWriter struct {
-	writers  []io.Writer
-	consoles []*os.File
-	files    []*os.File
+	mu      sync.RWMutex
+	wmu     sync.Mutex
+	dests   []*Destination
+	policy  WritePolicy
+	timeout time.Duration
 }
 
 // NewMultiWriter returns an initialized MultiWriter object.
@@ -35,62 +512,264 @@ func NewMultiWriter() (this *MultiWriter) {
 	return new(MultiWriter)
 }
 
+// SetPolicy sets how Write handles a failed destination. The default is
+// BestEffort.
+func (this *MultiWriter) SetPolicy(p WritePolicy) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.policy = p
+}
+
+// SetParallel enables parallel fan-out: each Write sends to all
+// destinations at once and waits at most timeout for them, so one slow
+// destination does not delay the others. A destination that times out is
+// reported with ErrWriteTimeout and skipped, with ErrDestinationBusy, until
+// its write finishes. Remove and Replace wait for such a write before
+// closing the destination; Close waits at most the timeout. A timeout of
+// zero restores sequential writes. The FailFast policy applies only to
+// sequential writes.
+func (this *MultiWriter) SetParallel(timeout time.Duration) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.timeout = timeout
+}
+
+// add appends a destination and returns its handle.
+func (this *MultiWriter) add(kind string, w io.Writer) (d *Destination) {
+
+	d = &Destination{
+		mw:    this,
+		kind:  kind,
+		name:  destName(w),
+		w:     w,
+		owned: kind == DestFile || kind == DestNetwork,
+	}
+	d.idle = sync.NewCond(&d.mu)
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.dests = append(this.dests, d)
+
+	return d
+}
+
 // AddWriter appends a writer to a MultiWriter writer.
-func (this *MultiWriter) AddWriter(w io.Writer) {
-	this.writers = append(this.writers, w)
+func (this *MultiWriter) AddWriter(w io.Writer) *Destination {
+	return this.add(DestWriter, w)
 }
 
-// AddFile appends a file to a MultiWriter writer.
-func (this *MultiWriter) AddFile(f string) {
+// AddFile appends a file to a MultiWriter writer. It returns nil if the
+// file cannot be opened; use AddFileOptions to get the error.
+func (this *MultiWriter) AddFile(f string) *Destination {
 
-	var err error
-	var h *os.File
+	d, err := this.AddFileOptions(f, FileOptions{})
 
-	if err = os.MkdirAll(filepath.Dir(f), DirModeDefault); err == nil {
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return nil
+	}
+
+	return d
+}
 
-		if h, err = os.OpenFile(f, FileFlagsAppend, FileModeDefault); err == nil {
-			this.files = append(this.files, h)
+// AddFileOptions appends a file opened with the given options. If the
+// options set a rotation policy, the destination's writer is a
+// RotatingFile.
+func (this *MultiWriter) AddFileOptions(f string, o FileOptions) (*Destination, error) {
+
+	if o.Rotate.IsZero() {
+
+		fh, err := o.Open(f)
+
+		if err != nil {
+			return nil, err
 		}
+
+		return this.add(DestFile, fh), nil
 	}
 
+	rf, err := NewRotatingFile(f, o)
+
 	if err != nil {
-		log.Printf(`%v`, ErrorDecorator(err))
+		return nil, err
 	}
+
+	return this.add(DestFile, rf), nil
 }
 
 // AddConsole appends a console to a MultiWriter writer. Consoles are
 // treated separately as they shouldn't be closed on termination.
-func (this *MultiWriter) AddConsole(h *os.File) {
-	this.consoles = append(this.consoles, h)
+func (this *MultiWriter) AddConsole(h *os.File) *Destination {
+	return this.add(DestConsole, h)
 }
 
-// Write writes output to each writer in MultiWriter.
+// AddNetwork appends a network destination; see NewNetWriter for the
+// supported networks. The destination is dialed on first write and
+// redialed after errors, with output held in a backlog meanwhile. The
+// NetWriter can be tuned through the handle's Writer method.
+func (this *MultiWriter) AddNetwork(network, address string) (*Destination, error) {
+
+	nw, err := NewNetWriter(network, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this.add(DestNetwork, nw), nil
+}
+
+// Destinations describes each destination in the order they are written.
+func (this *MultiWriter) Destinations() (dis []DestinationInfo) {
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	for _, d := range this.dests {
+		dis = append(dis, d.Info())
+	}
+
+	return dis
+}
+
+// Write writes output to each destination in MultiWriter, skipping paused
+// ones. Each destination receives b without its line ending, framed as set
+// by Destination.SetFraming: by default writers get no line ending and
+// consoles, files and network destinations a single newline. It returns
+// len(b) if every destination accepts its output. Otherwise it returns a
+// *MultiWriteError and the number of bytes of b that reached every
+// destination: as framing and transforms change the length of the output,
+// a destination counts as having received b whole or not at all, so this
+// is zero. Under FailFast the remaining destinations are not written after
+// the first failure. The error is not logged, as the MultiWriter may
+// itself be the output of the standard logger; Destinations reports it as
+// well.
 func (this *MultiWriter) Write(b []byte) (n int, err error) {
 
-	var errs int
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	var (
+		merr = &MultiWriteError{Total: len(this.dests)}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(b, []byte("\n")), []byte("\r"))
+		jobs []destJob
+	)
+
+	for i, d := range this.dests {
+
+		w, p := d.writer(b, line)
 
-	b = bytes.TrimSuffix(b, []byte("\n"))
-	b = bytes.TrimSuffix(b, []byte("\r"))
+		if w == nil {
+			continue
+		}
+
+		jobs = append(jobs, destJob{i, d, w, p})
+	}
 
-	for _, w := range this.writers {
-		if n, err = w.Write(b); err != nil { errs++ }
+	var fail = func(j destJob, werr error) {
+		merr.Errors = append(merr.Errors, &DestinationError{j.d.kind, destName(j.w), j.i, werr})
 	}
 
-	b = append(b, byte('\n'))
+	if this.timeout > 0 {
+		this.writeParallel(jobs, fail)
+	} else {
+		for _, j := range jobs {
+
+			m, werr := j.w.Write(j.p)
 
-	for _, c := range this.consoles {
-		if n, err = c.Write(b); err != nil { errs++ }
+			if werr == nil && m < len(j.p) {
+				werr = io.ErrShortWrite
+			}
+
+			j.d.record(werr)
+
+			if werr != nil {
+				if fail(j, werr); this.policy == FailFast {
+					break
+				}
+			}
+		}
 	}
 
-	for _, f := range this.files {
-		if n, err = f.Write(b); err != nil { errs++ }
+	if len(merr.Errors) == 0 {
+		return len(b), nil
 	}
-	if errs > 0 {
-		err = fmt.Errorf(`%d write errors`, errs)
-		log.Printf(`%v`, ErrorDecorator(err))
+
+	return 0, merr
+}
+
+// destJob is the output for one destination during a Write.
+type destJob struct {
+	i int
+	d *Destination
+	w io.Writer
+	p []byte
+}
+
+// writeParallel writes every job concurrently and waits up to the timeout,
+// calling fail for each destination that fails or does not finish. Writes
+// that time out continue in the background with their own copy of the
+// output.
+func (this *MultiWriter) writeParallel(jobs []destJob, fail func(destJob, error)) {
+
+	type result struct {
+		m   int
+		err error
 	}
 
-	return n, err
+	results := make([]chan result, len(jobs))
+
+	for k, j := range jobs {
+
+		results[k] = make(chan result, 1)
+
+		if !j.d.start() {
+			results[k] <- result{0, ErrDestinationBusy}
+			continue
+		}
+
+		go func(j destJob, p []byte, ch chan<- result) {
+			m, err := j.w.Write(p)
+			if err == nil && m < len(p) {
+				err = io.ErrShortWrite
+			}
+			j.d.finish()
+			ch <- result{m, err}
+		}(j, append([]byte(nil), j.p...), results[k])
+	}
+
+	timer := time.NewTimer(this.timeout)
+	defer timer.Stop()
+
+	var expired bool
+
+	for k, j := range jobs {
+
+		var r result
+
+		if expired {
+			select {
+			case r = <-results[k]:
+			default:
+				r.err = ErrWriteTimeout
+			}
+		} else {
+			select {
+			case r = <-results[k]:
+			case <-timer.C:
+				expired, r.err = true, ErrWriteTimeout
+			}
+		}
+
+		j.d.record(r.err)
+
+		if r.err != nil {
+			fail(j, r.err)
+		}
+	}
 }
 
 //WriteString converts string input to []byte and then calls Write.
@@ -103,47 +782,84 @@ func (this *MultiWriter) WriteError(e error) {
 	this.Println(e)
 }
 
-// Println writes to each writer in default format with trailing newline.
-func (this *MultiWriter) Println(t ...interface{}) {
+// Print formats its arguments as fmt.Sprint does and writes the result
+// once through Write.
+func (this *MultiWriter) Print(v ...interface{}) (n int, err error) {
+	return this.WriteString(fmt.Sprint(v...))
+}
 
-	for _, w := range this.writers {
-		if _, err := fmt.Fprintln(w, t); err != nil {
-			log.Printf(`%v`, ErrorDecorator(err))
-		}
-	}
+// Printf formats its arguments as fmt.Sprintf does and writes the result
+// once through Write.
+func (this *MultiWriter) Printf(format string, v ...interface{}) (n int, err error) {
+	return this.WriteString(fmt.Sprintf(format, v...))
+}
 
-	for _, c := range this.consoles {
-		if _, err := fmt.Fprintln(c, t); err != nil {
-			log.Printf(`%v`, ErrorDecorator(err))
-		}
-	}
+// Println formats its arguments as fmt.Sprintln does and writes the result
+// once through Write.
+func (this *MultiWriter) Println(v ...interface{}) (n int, err error) {
+	return this.WriteString(fmt.Sprintln(v...))
+}
 
-	for _, f := range this.files {
-		if _, err := fmt.Fprintln(f, t); err != nil {
-			log.Printf(`%v`, ErrorDecorator(err))
-		}
-	}
+// Errorf formats an error as fmt.Errorf does, writes its message through
+// Write and returns it.
+func (this *MultiWriter) Errorf(format string, v ...interface{}) error {
+	err := fmt.Errorf(format, v...)
+	this.WriteString(err.Error())
+	return err
+}
+
+// NewLogger returns a *log.Logger that writes to the MultiWriter.
+func (this *MultiWriter) NewLogger(prefix string, flag int) *log.Logger {
+	return log.New(this, prefix, flag)
 }
 
 // Count returns the number of writers in MultiWriter.
 func (this *MultiWriter) Count() (n int) {
-	return len(this.writers) + len(this.consoles) + len(this.files)
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return len(this.dests)
 }
 
 // Sync syncs underlying file and console writers in MultiWriter.
 func (this *MultiWriter) Sync() {
-	for _, c := range this.consoles{
-		c.Sync()
-	}
-	for _, f := range this.files {
-		f.Sync()
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	for _, d := range this.dests {
+		if f, ok := d.w.(interface{ Sync() error }); ok && d.kind != DestWriter {
+			f.Sync()
+		}
 	}
 }
 
-// Close syncs and closes underlying file writers in MultiWriter.
+// Close syncs underlying file and console writers in MultiWriter and
+// closes the writers its destinations own. It waits up to the parallel
+// timeout, in total, for parallel writes still running; a destination
+// whose write has not returned by then is closed under it, which for a
+// network destination also unblocks the write. Close therefore returns
+// even if a destination never does.
 func (this *MultiWriter) Close() {
+
 	this.Sync()
-	for _, f := range this.files {
-		f.Close()
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	deadline := time.Now().Add(this.timeout)
+
+	for _, d := range this.dests {
+
+		if !d.waitFor(time.Until(deadline)) {
+			reportError(ErrorDecorator(fmt.Errorf(`%s %s: closing with a write in progress`, d.kind, d.name)))
+		}
+
+		d.mu.Lock()
+		c := d.closer()
+		d.mu.Unlock()
+
+		if c != nil {
+			c.Close()
+		}
 	}
 }
@@ -0,0 +1,605 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`errors`
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`path/filepath`
+	`strings`
+	`sync`
+	`testing`
+	`time`
+)
+
+var errTestSink = errors.New(`sink failed`)
+
+// failWriter fails every write after writing n bytes of it.
+type failWriter struct {
+	n     int
+	calls int
+}
+
+func (this *failWriter) Write(b []byte) (int, error) {
+
+	this.calls++
+
+	if this.n > len(b) {
+		return len(b), errTestSink
+	}
+
+	return this.n, errTestSink
+}
+
+// shortWriter writes all but the last byte without an error.
+type shortWriter struct{}
+
+func (shortWriter) Write(b []byte) (int, error) {
+	return len(b) - 1, nil
+}
+
+func TestMultiWriterErrors(t *testing.T) {
+
+	first, good := new(bytes.Buffer), new(bytes.Buffer)
+	bad := &failWriter{n: 3}
+
+	mw := NewMultiWriter()
+	mw.AddWriter(first)
+	mw.AddWriter(bad)
+	mw.AddWriter(shortWriter{})
+	mw.AddWriter(good)
+
+	n, err := mw.Write([]byte("hello\n"))
+
+	// A destination that took part of the output has not received b.
+
+	if n != 0 {
+		t.Errorf(`n = %d, want 0`, n)
+	}
+
+	var merr *MultiWriteError
+
+	if !errors.As(err, &merr) {
+		t.Fatalf(`error %v is not a *MultiWriteError`, err)
+	}
+
+	if merr.Total != 4 || len(merr.Errors) != 2 {
+		t.Fatalf(`got %d of %d failed, want 2 of 4`, len(merr.Errors), merr.Total)
+	}
+
+	if merr.Errors[0].Index != 1 || merr.Errors[1].Index != 2 {
+		t.Errorf(`failed indexes %d and %d, want 1 and 2`, merr.Errors[0].Index, merr.Errors[1].Index)
+	}
+
+	if !errors.Is(err, errTestSink) || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf(`errors.Is does not see the destination errors in %v`, err)
+	}
+
+	var derr *DestinationError
+
+	if !errors.As(err, &derr) || derr.Kind != DestWriter || derr.Index != 1 {
+		t.Errorf(`errors.As found %+v`, derr)
+	}
+
+	if first.String() != `hello` || good.String() != `hello` {
+		t.Errorf(`healthy destinations got %q and %q`, first.String(), good.String())
+	}
+
+	if di := mw.Destinations()[1]; di.Errors != 1 || di.LastError != errTestSink.Error() {
+		t.Errorf(`failed destination info %+v`, di)
+	}
+}
+
+func TestMultiWriterPolicy(t *testing.T) {
+
+	for _, tc := range []struct {
+		policy WritePolicy
+		after  string
+		errs   int
+	}{
+		{BestEffort, `hello`, 2},
+		{FailFast, ``, 1},
+	} {
+
+		after := new(bytes.Buffer)
+		bad := &failWriter{}
+
+		mw := NewMultiWriter()
+		mw.SetPolicy(tc.policy)
+		mw.AddWriter(bad)
+		mw.AddWriter(&failWriter{})
+		mw.AddWriter(after)
+
+		n, err := mw.Write([]byte("hello\n"))
+
+		var merr *MultiWriteError
+
+		if n != 0 || !errors.As(err, &merr) {
+			t.Fatalf(`policy %d: got %d, %v`, tc.policy, n, err)
+		}
+
+		if len(merr.Errors) != tc.errs || after.String() != tc.after {
+			t.Errorf(`policy %d: %d errors and %q after the failure, want %d and %q`,
+				tc.policy, len(merr.Errors), after.String(), tc.errs, tc.after)
+		}
+	}
+}
+
+func TestMultiWriterCount(t *testing.T) {
+
+	mw := NewMultiWriter()
+	mw.AddWriter(new(bytes.Buffer)).SetFraming(FrameLength)
+	mw.AddWriter(new(bytes.Buffer)).SetTransforms(AddPrefix(`prefix: `))
+
+	// The destinations write more bytes than b holds; Write still counts
+	// only b.
+
+	if n, err := mw.Write([]byte("hello\n")); n != 6 || err != nil {
+		t.Errorf(`got %d, %v, want 6, nil`, n, err)
+	}
+
+	if n, err := NewMultiWriter().Write([]byte("hello\n")); n != 6 || err != nil {
+		t.Errorf(`no destinations: got %d, %v, want 6, nil`, n, err)
+	}
+}
+
+func TestMultiWriterFraming(t *testing.T) {
+
+	cases := []struct {
+		framing Framing
+		want    string
+	}{
+		{FrameLF, "hello\nhi\n"},
+		{FrameCRLF, "hello\r\nhi\r\n"},
+		{FrameNUL, "hello\x00hi\x00"},
+		{FrameLength, "\x00\x00\x00\x05hello\x00\x00\x00\x02hi"},
+		{FrameRaw, "hello\r\nhi\n"},
+		{FrameNone, "hellohi"},
+		{FrameDefault, "hellohi"},
+	}
+
+	for _, tc := range cases {
+
+		bb := new(bytes.Buffer)
+		mw := NewMultiWriter()
+		mw.AddWriter(bb).SetFraming(tc.framing)
+
+		// Write strips either line ending before framing; only FrameRaw
+		// keeps the caller's.
+
+		mw.Write([]byte("hello\r\n"))
+		mw.Write([]byte("hi\n"))
+
+		if bb.String() != tc.want {
+			t.Errorf(`%s: got %q, want %q`, tc.framing, bb.String(), tc.want)
+		}
+
+		if f, err := ParseFraming(tc.framing.String()); err != nil || f != tc.framing {
+			t.Errorf(`%s: ParseFraming returned %s, %v`, tc.framing, f, err)
+		}
+	}
+}
+
+func TestMultiWriterPrint(t *testing.T) {
+
+	bb := new(bytes.Buffer)
+	mw := NewMultiWriter()
+	mw.AddWriter(bb).SetFraming(FrameLF)
+
+	// The count is that of the formatted text, which Println ends with
+	// a newline and Print and Printf do not.
+
+	cases := []struct {
+		call func() (int, error)
+		want string
+		n    int
+	}{
+		{func() (int, error) { return mw.Println(`a`, 1, true) }, "a 1 true\n", 9},
+		{func() (int, error) { return mw.Println([]string{`x`, `y`}) }, "[x y]\n", 6},
+		{func() (int, error) { return mw.Print(`a`, `b`) }, "ab\n", 2},
+		{func() (int, error) { return mw.Print(1, 2) }, "1 2\n", 3},
+		{func() (int, error) { return mw.Printf(`%d-%s`, 1, `x`) }, "1-x\n", 3},
+	}
+
+	for _, tc := range cases {
+
+		bb.Reset()
+		n, err := tc.call()
+
+		if bb.String() != tc.want || n != tc.n || err != nil {
+			t.Errorf(`got %q, %d, %v, want %q, %d`, bb.String(), n, err, tc.want, tc.n)
+		}
+	}
+
+	bb.Reset()
+	err := mw.Errorf(`write failed: %w`, errTestSink)
+
+	if !errors.Is(err, errTestSink) || bb.String() != "write failed: sink failed\n" {
+		t.Errorf(`Errorf returned %v and wrote %q`, err, bb.String())
+	}
+
+	bb.Reset()
+	mw.WriteError(errTestSink)
+
+	if bb.String() != "sink failed\n" {
+		t.Errorf(`WriteError wrote %q`, bb.String())
+	}
+
+	bb.Reset()
+	mw.NewLogger(`prefix: `, 0).Print(`from a logger`)
+
+	if bb.String() != "prefix: from a logger\n" {
+		t.Errorf(`NewLogger wrote %q`, bb.String())
+	}
+}
+
+func TestMultiWriterPrintFile(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), `test.log`)
+
+	mw := NewMultiWriter()
+
+	if mw.AddFile(fn) == nil {
+		t.Fatal(`AddFile failed`)
+	}
+
+	// Files end every line with exactly one newline, whether or not the
+	// formatted text has one.
+
+	mw.Print(`no newline`)
+	mw.Println(`with`, `newline`)
+	mw.Printf("crlf %d\r\n", 3)
+	mw.Close()
+
+	b, err := os.ReadFile(fn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "no newline\nwith newline\ncrlf 3\n"; string(b) != want {
+		t.Errorf(`file holds %q, want %q`, b, want)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent use.
+type lockedBuffer struct {
+	mu sync.Mutex
+	bb bytes.Buffer
+}
+
+func (this *lockedBuffer) Write(b []byte) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.bb.Write(b)
+}
+
+func (this *lockedBuffer) String() string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.bb.String()
+}
+
+// blockWriter blocks every write until release is closed.
+type blockWriter struct {
+	lockedBuffer
+	release chan struct{}
+}
+
+func newBlockWriter() *blockWriter {
+	return &blockWriter{release: make(chan struct{})}
+}
+
+func (this *blockWriter) Write(b []byte) (int, error) {
+	<-this.release
+	return this.lockedBuffer.Write(b)
+}
+
+// writeLines writes n numbered lines from each of g goroutines.
+func writeLines(mw *MultiWriter, g, n int) {
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < g; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				mw.Printf("goroutine %d line %d\n", i, j)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestMultiWriterConcurrent(t *testing.T) {
+
+	const g, n = 4, 200
+
+	var (
+		mw   = NewMultiWriter()
+		bb   = new(lockedBuffer)
+		fn   = filepath.Join(t.TempDir(), `test.log`)
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	mw.AddWriter(bb).SetFraming(FrameLF)
+	d := mw.AddWriter(new(lockedBuffer)).SetFraming(FrameLF)
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if f := mw.AddFile(fn); f != nil {
+				if err := f.Remove(); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := d.Replace(new(lockedBuffer)); err != nil {
+				t.Error(err)
+			}
+			mw.Destinations()
+		}
+	}()
+
+	writeLines(mw, g, n)
+	close(done)
+	wg.Wait()
+
+	if got := strings.Count(bb.String(), "\n"); got != g*n {
+		t.Errorf(`got %d lines, want %d`, got, g*n)
+	}
+
+	if mw.Count() != 2 {
+		t.Errorf(`%d destinations left, want 2`, mw.Count())
+	}
+}
+
+func TestMultiWriterLineAtomicity(t *testing.T) {
+
+	const g, n = 8, 100
+
+	for _, timeout := range []time.Duration{0, time.Second} {
+
+		mw := NewMultiWriter()
+		mw.SetParallel(timeout)
+
+		var bbs [3]*lockedBuffer
+
+		for i := range bbs {
+			bbs[i] = new(lockedBuffer)
+			mw.AddWriter(bbs[i]).SetFraming(FrameLF)
+		}
+
+		writeLines(mw, g, n)
+
+		lines := strings.Split(strings.TrimSuffix(bbs[0].String(), "\n"), "\n")
+
+		if len(lines) != g*n {
+			t.Fatalf(`timeout %v: got %d lines, want %d`, timeout, len(lines), g*n)
+		}
+
+		for _, line := range lines {
+			var i, j int
+			if _, err := fmt.Sscanf(line, `goroutine %d line %d`, &i, &j); err != nil {
+				t.Fatalf(`timeout %v: interleaved line %q`, timeout, line)
+			}
+		}
+
+		// Lines are in the same order at every destination.
+
+		for _, bb := range bbs[1:] {
+			if bb.String() != bbs[0].String() {
+				t.Errorf(`timeout %v: destinations differ`, timeout)
+			}
+		}
+	}
+}
+
+func TestMultiWriterTimeout(t *testing.T) {
+
+	var (
+		mw   = NewMultiWriter()
+		good = new(lockedBuffer)
+		slow = newBlockWriter()
+	)
+
+	mw.SetParallel(20 * time.Millisecond)
+	mw.AddWriter(good).SetFraming(FrameLF)
+	mw.AddWriter(slow).SetFraming(FrameLF)
+
+	for _, want := range []error{ErrWriteTimeout, ErrDestinationBusy} {
+
+		n, err := mw.WriteString("line\n")
+
+		var derr *DestinationError
+
+		if n != 0 || !errors.Is(err, want) || !errors.As(err, &derr) || derr.Index != 1 {
+			t.Fatalf(`got %d, %v, want 0 and %v for destination 1`, n, err, want)
+		}
+	}
+
+	close(slow.release)
+
+	// The timed-out write finishes in the background; the line dropped
+	// while it ran is not written late.
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if _, err := mw.WriteString("next\n"); err == nil {
+			break
+		} else if !errors.Is(err, ErrDestinationBusy) || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+
+	if got := slow.String(); got != "line\nnext\n" {
+		t.Errorf(`slow destination got %q`, got)
+	}
+
+	if got := good.String(); !strings.HasPrefix(got, "line\nline\n") || !strings.HasSuffix(got, "next\n") {
+		t.Errorf(`good destination got %q`, got)
+	}
+}
+
+func TestMultiWriterCloseHungWrite(t *testing.T) {
+
+	defer setErrorLogger(setErrorLogger(log.New(io.Discard, ``, 0)))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), `test.log`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := newBlockWriter()
+	defer close(slow.release)
+
+	mw := NewMultiWriter()
+	mw.SetParallel(20 * time.Millisecond)
+	mw.AddWriter(slow)
+	mw.add(DestFile, f)
+
+	if _, err := mw.WriteString("line\n"); !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf(`got %v, want %v`, err, ErrWriteTimeout)
+	}
+
+	closed := make(chan struct{})
+
+	go func() {
+		mw.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`Close blocked on a write that never returns`)
+	}
+
+	if _, err := f.Write([]byte(`x`)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf(`file destination not closed: %v`, err)
+	}
+}
+
+// closeRecorder is a writer that records whether it was closed.
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (this *closeRecorder) Close() error {
+	this.closed = true
+	return nil
+}
+
+func TestDestinationReplace(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// A file replaced with a standard stream: the file is closed, the
+	// stream is not.
+
+	mw := NewMultiWriter()
+	d := mw.AddFile(filepath.Join(dir, `a.log`))
+	f := d.Writer().(*os.File)
+
+	if err := d.Replace(os.Stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte(`x`)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf(`replaced file not closed: %v`, err)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stderr.Stat(); err != nil {
+		t.Fatalf(`removing the destination closed stderr: %v`, err)
+	}
+
+	// A writer replaced with a file: the MultiWriter now owns the file.
+
+	f, err := os.Create(filepath.Join(dir, `b.log`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := new(closeRecorder)
+
+	mw = NewMultiWriter()
+	d = mw.AddWriter(cr)
+
+	if err := d.Replace(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if cr.closed {
+		t.Error(`writer passed to AddWriter was closed`)
+	}
+
+	// Replacing a writer with itself leaves it open.
+
+	if err := d.Replace(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte(`x`)); err != nil {
+		t.Errorf(`file replaced with itself: %v`, err)
+	}
+
+	if err := d.Replace(cr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte(`x`)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf(`file passed to Replace not closed: %v`, err)
+	}
+
+	if mw.Close(); !cr.closed {
+		t.Error(`writer passed to Replace not closed by Close`)
+	}
+
+	if di := mw.Destinations()[0]; di.Kind != DestWriter {
+		t.Errorf(`kind %q, want %q`, di.Kind, DestWriter)
+	}
+}
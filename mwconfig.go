@@ -0,0 +1,322 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`crypto/tls`
+	`crypto/x509`
+	`encoding/json`
+	`fmt`
+	`io`
+	`io/ioutil`
+	`os`
+	`strconv`
+	`time`
+)
+
+// FileConfig describes the FileOptions of a file destination in JSON.
+// Mode and DirMode are octal strings such as "0640".
+type FileConfig struct {
+	Mode string
+	DirMode string
+	Truncate bool
+	Exclusive bool
+	Owner string
+	Group string
+	Rotate RotatePolicy
+}
+
+// Build returns the file options.
+func (this FileConfig) Build() (o FileOptions, err error) {
+
+	o = FileOptions{
+		Truncate: this.Truncate,
+		Exclusive: this.Exclusive,
+		Owner: this.Owner,
+		Group: this.Group,
+		Rotate: this.Rotate,
+	}
+
+	if o.Mode, err = parseFileMode(this.Mode); err != nil {
+		return o, err
+	}
+
+	if o.DirMode, err = parseFileMode(this.DirMode); err != nil {
+		return o, err
+	}
+
+	if _, err = ParseAge(this.Rotate.MaxAge); err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// parseFileMode parses an octal permission string. An empty string yields
+// zero, which selects the default mode.
+func parseFileMode(s string) (os.FileMode, error) {
+
+	if s == `` {
+		return 0, nil
+	}
+
+	m, err := strconv.ParseUint(s, 8, 32)
+
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf(`invalid file mode %q`, s)
+	}
+
+	return os.FileMode(m), nil
+}
+
+// NetConfig describes the NetWriter of a network destination in JSON.
+// Timeouts and backoffs are durations such as "5s"; empty keeps the
+// default. Backlog is the number of messages held while disconnected:
+// zero keeps the default and a negative number disables the backlog. The
+// TLS settings apply to "tcp+tls" only; CAFile replaces the system roots,
+// and CertFile and KeyFile supply a client certificate.
+type NetConfig struct {
+	DialTimeout string
+	WriteTimeout string
+	MinBackoff string
+	MaxBackoff string
+	Backlog int
+	ServerName string
+	CAFile string
+	CertFile string
+	KeyFile string
+}
+
+// Apply sets the options of a NetWriter.
+func (this NetConfig) Apply(nw *NetWriter) (err error) {
+
+	var (
+		dial = NetDialTimeout
+		write = NetWriteTimeout
+		min = NetMinBackoff
+		max = NetMaxBackoff
+	)
+
+	for _, d := range []struct{ s string; p *time.Duration }{
+		{this.DialTimeout, &dial},
+		{this.WriteTimeout, &write},
+		{this.MinBackoff, &min},
+		{this.MaxBackoff, &max},
+	} {
+		if d.s == `` {
+			continue
+		}
+		if *d.p, err = time.ParseDuration(d.s); err != nil {
+			return err
+		}
+	}
+
+	nw.SetTimeouts(dial, write)
+	nw.SetBackoff(min, max)
+
+	switch {
+	case this.Backlog < 0:
+		nw.SetBacklog(0)
+	case this.Backlog > 0:
+		nw.SetBacklog(this.Backlog)
+	}
+
+	if this.ServerName == `` && this.CAFile == `` && this.CertFile == `` {
+		return nil
+	}
+
+	tc := &tls.Config{ServerName: this.ServerName}
+
+	if this.CAFile != `` {
+
+		pem, err := ioutil.ReadFile(this.CAFile)
+
+		if err != nil {
+			return err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf(`%s: no certificates found`, this.CAFile)
+		}
+	}
+
+	if this.CertFile != `` {
+
+		cert, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile)
+
+		if err != nil {
+			return err
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	nw.SetTLSConfig(tc)
+
+	return nil
+}
+
+// DestinationConfig describes a MultiWriter destination in JSON. Kind is
+// "file", "console", "network" or "writer". Path is the file name; "stdout"
+// or "stderr" for a console; the address, in the form expected by
+// net.Dial, for a network destination; or the key in
+// MultiWriterConfig.Writers for a writer. Network is the network of a
+// network destination, as accepted by NewNetWriter. Framing is a name
+// accepted by ParseFraming. File and Net apply to file and network
+// destinations only.
+type DestinationConfig struct {
+	Kind string
+	Path string
+	Network string
+	Framing string
+	File FileConfig
+	Net NetConfig
+	Filter FilterConfig
+	Transform TransformConfig
+}
+
+// MultiWriterConfig describes a MultiWriter in JSON. Policy is
+// "best-effort" or "fail-fast"; Parallel is a timeout such as "2s" that
+// enables parallel fan-out. Writers, which is not read from JSON, holds
+// the writers that destinations of kind "writer" refer to by name.
+type MultiWriterConfig struct {
+	Policy string
+	Parallel string
+	Destinations []DestinationConfig
+	Writers map[string]io.Writer `json:"-"`
+}
+
+// Configure sets the filter and transforms of a destination from their
+// JSON descriptions.
+func (this *Destination) Configure(fc FilterConfig, tc TransformConfig) (err error) {
+
+	f, err := fc.Build()
+
+	if err != nil {
+		return err
+	}
+
+	ts, err := tc.Build()
+
+	if err != nil {
+		return err
+	}
+
+	this.SetFilter(f)
+	this.SetTransforms(ts...)
+
+	return nil
+}
+
+// NewMultiWriterFromConfig creates a MultiWriter from its description.
+// Files opened before an error is found are closed.
+func NewMultiWriterFromConfig(cfg MultiWriterConfig) (this *MultiWriter, err error) {
+
+	this = NewMultiWriter()
+
+	defer func() {
+		if err != nil {
+			this.Close()
+			this = nil
+		}
+	}()
+
+	switch cfg.Policy {
+	case ``, `best-effort`:
+		this.SetPolicy(BestEffort)
+	case `fail-fast`:
+		this.SetPolicy(FailFast)
+	default:
+		return this, fmt.Errorf(`unknown policy %q`, cfg.Policy)
+	}
+
+	if cfg.Parallel != `` {
+		d, err := time.ParseDuration(cfg.Parallel)
+		if err != nil {
+			return this, err
+		}
+		this.SetParallel(d)
+	}
+
+	for i, dc := range cfg.Destinations {
+
+		var d *Destination
+
+		fr, err := ParseFraming(dc.Framing)
+
+		if err != nil {
+			return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+		}
+
+		switch dc.Kind {
+		case DestFile:
+			o, err := dc.File.Build()
+			if err != nil {
+				return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+			}
+			if d, err = this.AddFileOptions(dc.Path, o); err != nil {
+				return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+			}
+		case DestConsole:
+			switch dc.Path {
+			case ``, `stdout`:
+				d = this.AddConsole(os.Stdout)
+			case `stderr`:
+				d = this.AddConsole(os.Stderr)
+			default:
+				return this, fmt.Errorf(`destinations[%d]: unknown console %q`, i, dc.Path)
+			}
+		case DestNetwork:
+			if d, err = this.AddNetwork(dc.Network, dc.Path); err != nil {
+				return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+			}
+			if err = dc.Net.Apply(d.Writer().(*NetWriter)); err != nil {
+				return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+			}
+		case DestWriter:
+			w, ok := cfg.Writers[dc.Path]
+			if !ok {
+				return this, fmt.Errorf(`destinations[%d]: unknown writer %q`, i, dc.Path)
+			}
+			d = this.AddWriter(w)
+		default:
+			return this, fmt.Errorf(`destinations[%d]: unknown kind %q`, i, dc.Kind)
+		}
+
+		if err = d.Configure(dc.Filter, dc.Transform); err != nil {
+			return this, fmt.Errorf(`destinations[%d]: %v`, i, err)
+		}
+
+		d.SetFraming(fr)
+	}
+
+	return this, nil
+}
+
+// LoadMultiWriter creates a MultiWriter from a MultiWriterConfig read as
+// JSON from r. Destinations of kind "writer" need NewMultiWriterFromConfig
+// with the writers supplied.
+func LoadMultiWriter(r io.Reader) (*MultiWriter, error) {
+
+	var cfg MultiWriterConfig
+
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	return NewMultiWriterFromConfig(cfg)
+}
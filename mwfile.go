@@ -0,0 +1,468 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`compress/gzip`
+	`fmt`
+	`io`
+	`os`
+	`os/user`
+	`path/filepath`
+	`sort`
+	`strconv`
+	`strings`
+	`sync`
+	`time`
+)
+
+// FileOptions controls how a file destination is opened. The zero value
+// appends to the file, creating it with FileModeDefault and its directory
+// with DirModeDefault, as AddFile does. Modes are subject to the umask.
+// Owner and Group are names or numeric IDs; empty leaves them unchanged.
+type FileOptions struct {
+	Mode os.FileMode
+	DirMode os.FileMode
+	Truncate bool
+	Exclusive bool
+	Owner string
+	Group string
+	Rotate RotatePolicy
+}
+
+// RotatePolicy selects when a file is rotated. MaxSize is in bytes and
+// MaxAge is a duration such as "24h" or "7d", counted from when the file
+// was opened. MaxBackups limits the rotated copies kept; zero keeps them
+// all. Compress gzips each rotated copy in the background.
+type RotatePolicy struct {
+	MaxSize int64
+	MaxAge string
+	MaxBackups int
+	Compress bool
+}
+
+// IsZero reports whether the policy never rotates.
+func (this RotatePolicy) IsZero() bool {
+	return this.MaxSize == 0 && this.MaxAge == ``
+}
+
+// Open creates the file's directory and opens the file.
+func (this FileOptions) Open(path string) (fh *os.File, err error) {
+
+	mode, dirMode := this.Mode, this.DirMode
+
+	if mode == 0 {
+		mode = FileModeDefault
+	}
+
+	if dirMode == 0 {
+		dirMode = DirModeDefault
+	}
+
+	flags := FileFlagsAppend
+
+	if this.Truncate {
+		flags |= os.O_TRUNC
+	}
+
+	if this.Exclusive {
+		flags |= os.O_EXCL
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return nil, err
+	}
+
+	if fh, err = os.OpenFile(path, flags, mode); err != nil {
+		return nil, err
+	}
+
+	if err = this.chown(path); err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	return fh, nil
+}
+
+// chown sets the owner and group of a file if either is configured.
+func (this FileOptions) chown(path string) (err error) {
+
+	if this.Owner == `` && this.Group == `` {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if this.Owner != `` {
+		if uid, err = strconv.Atoi(this.Owner); err != nil {
+			u, err := user.Lookup(this.Owner)
+			if err != nil {
+				return err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return fmt.Errorf(`user %s: uid %q is not numeric`, this.Owner, u.Uid)
+			}
+		}
+	}
+
+	if this.Group != `` {
+		if gid, err = strconv.Atoi(this.Group); err != nil {
+			g, err := user.LookupGroup(this.Group)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return fmt.Errorf(`group %s: gid %q is not numeric`, this.Group, g.Gid)
+			}
+		}
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// RotateTimeLayout is the timestamp appended to the name of a rotated
+// file. It sorts in time order, which is how backups are pruned.
+const RotateTimeLayout = `20060102-150405.000`
+
+// RotatingFile is an io.WriteCloser that writes to a file and, according
+// to a RotatePolicy, renames it with a timestamp suffix and starts a new
+// one. Rotated copies match the file's RetentionPattern, so retention and
+// logtail see them.
+type RotatingFile struct {
+	mu sync.Mutex
+	pmu sync.Mutex
+	wg sync.WaitGroup
+	path string
+	opts FileOptions
+	maxAge time.Duration
+	fh *os.File
+	size int64
+	opened time.Time
+}
+
+// NewRotatingFile opens a file that is rotated according to o.Rotate.
+func NewRotatingFile(path string, o FileOptions) (this *RotatingFile, err error) {
+
+	this = &RotatingFile{path: path, opts: o}
+
+	if this.maxAge, err = ParseAge(o.Rotate.MaxAge); err != nil {
+		return nil, err
+	}
+
+	if err = this.open(); err != nil {
+		return nil, err
+	}
+
+	// Truncate and Exclusive apply to the first open only; reopening
+	// after a failed rotation must not lose the file.
+
+	this.opts.Truncate, this.opts.Exclusive = false, false
+
+	return this, nil
+}
+
+// Name returns the path of the file.
+func (this *RotatingFile) Name() string {
+	return this.path
+}
+
+// Write writes to the file, first rotating it if the write would exceed
+// the size limit or the file has reached the age limit. If rotation
+// fails, output continues to go to the current file.
+func (this *RotatingFile) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.fh == nil {
+		if err = this.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if this.due(len(b)) {
+		if err = this.rotate(); err != nil {
+			reportError(ErrorDecorator(err))
+		}
+		if this.fh == nil {
+			return 0, err
+		}
+	}
+
+	n, err = this.fh.Write(b)
+	this.size += int64(n)
+
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (this *RotatingFile) Rotate() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.rotate()
+}
+
+// Sync commits the file to stable storage.
+func (this *RotatingFile) Sync() error {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.fh == nil {
+		return nil
+	}
+
+	return this.fh.Sync()
+}
+
+// Close closes the file and waits for pending compression to finish.
+func (this *RotatingFile) Close() (err error) {
+
+	this.mu.Lock()
+
+	if this.fh != nil {
+		err = this.fh.Close()
+		this.fh = nil
+	}
+
+	this.mu.Unlock()
+	this.wg.Wait()
+
+	return err
+}
+
+// due reports whether the file should be rotated before writing n bytes.
+// The caller must hold the lock.
+func (this *RotatingFile) due(n int) bool {
+
+	if this.size == 0 {
+		return false
+	}
+
+	if p := this.opts.Rotate; p.MaxSize > 0 && this.size + int64(n) > p.MaxSize {
+		return true
+	}
+
+	return this.maxAge > 0 && time.Since(this.opened) >= this.maxAge
+}
+
+// open opens the file. The caller must hold the lock, except during
+// construction.
+func (this *RotatingFile) open() (err error) {
+
+	fh, err := this.opts.Open(this.path)
+
+	if err != nil {
+		return err
+	}
+
+	fi, err := fh.Stat()
+
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	this.fh, this.size, this.opened = fh, fi.Size(), time.Now()
+
+	return nil
+}
+
+// rotate renames the file, opens a new one and hands the rotated copy to
+// compression and pruning. The caller must hold the lock.
+func (this *RotatingFile) rotate() (err error) {
+
+	backup := this.path + `.` + time.Now().Format(RotateTimeLayout)
+
+	for i := 1; fileExists(backup) || fileExists(backup + `.gz`); i++ {
+		backup = fmt.Sprintf(`%s.%s.%d`, this.path, time.Now().Format(RotateTimeLayout), i)
+	}
+
+	if this.fh != nil {
+		this.fh.Close()
+		this.fh = nil
+	}
+
+	if err = os.Rename(this.path, backup); err != nil {
+		if oerr := this.open(); oerr != nil {
+			reportError(ErrorDecorator(oerr))
+		}
+		return err
+	}
+
+	fh, err := this.opts.Open(this.path)
+
+	if err != nil {
+		return err
+	}
+
+	this.fh, this.size, this.opened = fh, 0, time.Now()
+
+	if !this.opts.Rotate.Compress {
+		this.prune()
+		return nil
+	}
+
+	this.wg.Add(1)
+
+	go func() {
+
+		defer this.wg.Done()
+
+		if err := this.compress(backup); err != nil {
+			reportError(ErrorDecorator(err))
+		}
+
+		this.prune()
+	}()
+
+	return nil
+}
+
+// compress gzips a rotated file and removes the original.
+func (this *RotatingFile) compress(path string) (err error) {
+
+	this.pmu.Lock()
+	defer this.pmu.Unlock()
+
+	src, err := os.Open(path)
+
+	// A copy pruned before its turn to be compressed is not an error.
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	opts := this.opts
+	opts.Truncate, opts.Exclusive = true, false
+
+	dst, err := opts.Open(path + `.gz`)
+
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+
+	_, err = io.Copy(zw, src)
+
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(path + `.gz`)
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// prune removes the oldest rotated copies beyond MaxBackups.
+func (this *RotatingFile) prune() {
+
+	if this.opts.Rotate.MaxBackups <= 0 {
+		return
+	}
+
+	this.pmu.Lock()
+	defer this.pmu.Unlock()
+
+	paths, err := filepath.Glob(this.path + `.*`)
+
+	if err != nil {
+		reportError(ErrorDecorator(err))
+		return
+	}
+
+	type backup struct {
+		path string
+		stamp time.Time
+		seq int
+	}
+
+	var backups []backup
+
+	for _, p := range paths {
+		if stamp, seq, ok := backupStamp(this.path, p); ok {
+			backups = append(backups, backup{p, stamp, seq})
+		}
+	}
+
+	// Copies rotated within the same millisecond carry a sequence number,
+	// which must compare numerically: .10 is newer than .2.
+
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].stamp.Equal(backups[j].stamp) {
+			return backups[i].stamp.Before(backups[j].stamp)
+		}
+		return backups[i].seq < backups[j].seq
+	})
+
+	for len(backups) > this.opts.Rotate.MaxBackups {
+		if err := os.Remove(backups[0].path); err != nil {
+			reportError(ErrorDecorator(err))
+		}
+		backups = backups[1:]
+	}
+}
+
+// backupStamp returns the timestamp in a rotated file's name and the
+// sequence number rotate appends when the name is taken, or zero. It
+// returns false if backup is not a rotated copy of path.
+func backupStamp(path, backup string) (stamp time.Time, seq int, ok bool) {
+
+	s := strings.TrimSuffix(strings.TrimPrefix(backup, path + `.`), `.gz`)
+
+	if len(s) < len(RotateTimeLayout) {
+		return stamp, 0, false
+	}
+
+	stamp, err := time.Parse(RotateTimeLayout, s[:len(RotateTimeLayout)])
+
+	if err != nil {
+		return stamp, 0, false
+	}
+
+	if s = s[len(RotateTimeLayout):]; s == `` {
+		return stamp, 0, true
+	}
+
+	if !strings.HasPrefix(s, `.`) {
+		return stamp, 0, false
+	}
+
+	if seq, err = strconv.Atoi(s[1:]); err != nil || seq < 1 {
+		return stamp, 0, false
+	}
+
+	return stamp, seq, true
+}
+
+// fileExists reports whether a file exists.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`compress/gzip`
+	`errors`
+	`io`
+	`os`
+	`path/filepath`
+	`sort`
+	`strconv`
+	`strings`
+	`testing`
+)
+
+func TestFileOptionsOpen(t *testing.T) {
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, `sub`, `test.log`)
+
+	fh, err := FileOptions{Mode: 0600, DirMode: 0700}.Open(fn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fh.WriteString("first\n")
+	fh.Close()
+
+	for path, want := range map[string]os.FileMode{fn: 0600, filepath.Dir(fn): 0700} {
+		if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != want {
+			t.Errorf(`%s: mode %v, %v, want %v`, path, fi.Mode().Perm(), err, want)
+		}
+	}
+
+	// Appending keeps the content; truncating discards it.
+
+	for _, tc := range []struct {
+		opts FileOptions
+		want string
+	}{
+		{FileOptions{}, "first\nsecond\n"},
+		{FileOptions{Truncate: true}, "second\n"},
+	} {
+
+		if fh, err = tc.opts.Open(fn); err != nil {
+			t.Fatal(err)
+		}
+
+		fh.WriteString("second\n")
+		fh.Close()
+
+		if b, _ := os.ReadFile(fn); string(b) != tc.want {
+			t.Errorf(`%+v: got %q, want %q`, tc.opts, b, tc.want)
+		}
+
+		os.WriteFile(fn, []byte("first\n"), 0600)
+	}
+
+	if _, err = (FileOptions{Exclusive: true}).Open(fn); !errors.Is(err, os.ErrExist) {
+		t.Errorf(`exclusive open of an existing file: %v`, err)
+	}
+
+	if fh, err = (FileOptions{Exclusive: true}).Open(fn + `.new`); err != nil {
+		t.Errorf(`exclusive open of a new file: %v`, err)
+	} else {
+		fh.Close()
+	}
+}
+
+func TestFileOptionsChown(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), `test.log`)
+
+	// Chowning to the current owner and group is always permitted.
+
+	opts := FileOptions{Owner: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())}
+
+	if fh, err := opts.Open(fn); err != nil {
+		t.Fatal(err)
+	} else {
+		fh.Close()
+	}
+
+	opts = FileOptions{Owner: `no-such-user-goutil`}
+
+	if _, err := opts.Open(fn); err == nil {
+		t.Error(`unknown owner accepted`)
+	}
+
+	opts = FileOptions{Group: `no-such-group-goutil`}
+
+	if _, err := opts.Open(fn); err == nil {
+		t.Error(`unknown group accepted`)
+	}
+}
+
+func TestAddFileOptions(t *testing.T) {
+
+	dir := t.TempDir()
+	mw := NewMultiWriter()
+
+	d, err := mw.AddFileOptions(filepath.Join(dir, `plain.log`), FileOptions{Mode: 0600})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := d.Writer().(*os.File); !ok {
+		t.Errorf(`writer is %T, want *os.File`, d.Writer())
+	}
+
+	d, err = mw.AddFileOptions(filepath.Join(dir, `rotating.log`), FileOptions{Rotate: RotatePolicy{MaxSize: 100}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := d.Writer().(*RotatingFile); !ok {
+		t.Errorf(`writer is %T, want *RotatingFile`, d.Writer())
+	}
+
+	if _, err = mw.AddFileOptions(filepath.Join(dir, `plain.log`), FileOptions{Exclusive: true}); err == nil {
+		t.Error(`exclusive AddFileOptions of an existing file succeeded`)
+	}
+
+	if _, err = mw.AddFileOptions(filepath.Join(dir, `bad.log`), FileOptions{Rotate: RotatePolicy{MaxAge: `x`}}); err == nil {
+		t.Error(`invalid MaxAge accepted`)
+	}
+
+	if mw.Count() != 2 {
+		t.Errorf(`%d destinations, want 2`, mw.Count())
+	}
+
+	mw.Close()
+}
+
+// backups returns the rotated copies of a file, oldest first.
+func backups(t *testing.T, fn string) []string {
+
+	paths, err := filepath.Glob(fn + `.*`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		si, qi, _ := backupStamp(fn, paths[i])
+		sj, qj, _ := backupStamp(fn, paths[j])
+		return si.Before(sj) || si.Equal(sj) && qi < qj
+	})
+
+	return paths
+}
+
+func TestRotatingFile(t *testing.T) {
+
+	for _, compress := range []bool{false, true} {
+
+		fn := filepath.Join(t.TempDir(), `test.log`)
+
+		rf, err := NewRotatingFile(fn, FileOptions{Rotate: RotatePolicy{MaxSize: 10, MaxBackups: 2, Compress: compress}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each line fills the file, so every write after the first rotates.
+
+		for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+			if _, err := rf.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := rf.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := os.ReadFile(fn); string(b) != "line 4\n" {
+			t.Errorf(`compress %v: current file has %q`, compress, b)
+		}
+
+		paths := backups(t, fn)
+
+		if len(paths) != 2 {
+			t.Fatalf(`compress %v: backups %q, want 2`, compress, paths)
+		}
+
+		for i, path := range paths {
+
+			if strings.HasSuffix(path, `.gz`) != compress {
+				t.Errorf(`compress %v: backup %s`, compress, path)
+			}
+
+			f, err := os.Open(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader = f
+
+			if compress {
+				if r, err = gzip.NewReader(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b, err := io.ReadAll(r)
+			f.Close()
+
+			if want := "line " + strconv.Itoa(i + 2) + "\n"; err != nil || string(b) != want {
+				t.Errorf(`compress %v: backup %s has %q, %v, want %q`, compress, path, b, err, want)
+			}
+		}
+	}
+}
+
+func TestRotatingFilePruneSequence(t *testing.T) {
+
+	fn := filepath.Join(t.TempDir(), `test.log`)
+	stamp := fn + `.20260101-000000.000`
+
+	// Rotations within the same millisecond get .1, .2 ... .10; .10 is
+	// the newest although it sorts before .2 as a string.
+
+	for _, name := range []string{stamp, stamp + `.1.gz`, stamp + `.2`, stamp + `.10`, fn + `.other`} {
+		if err := os.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rf, err := NewRotatingFile(fn, FileOptions{Rotate: RotatePolicy{MaxSize: 10, MaxBackups: 2}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rf.prune()
+	rf.Close()
+
+	want := []string{fn + `.other`, stamp + `.2`, stamp + `.10`}
+
+	if got := backups(t, fn); strings.Join(got, ` `) != strings.Join(want, ` `) {
+		t.Errorf(`kept %q, want %q`, got, want)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`fmt`
+	`os`
+	`path/filepath`
+	`regexp`
+	`strings`
+	`time`
+)
+
+// LineFilter reports whether a line, without its line ending, should be
+// written to a destination.
+type LineFilter func(line []byte) bool
+
+// LineTransform rewrites a line, without its line ending, before it is
+// written to a destination.
+type LineTransform func(line []byte) []byte
+
+// levelNames maps the level words found in log lines to the canonical
+// level names.
+var levelNames = map[string]string{
+	`trace`: `debug`,
+	`debug`: `debug`,
+	`info`: `info`,
+	`warn`: `warn`,
+	`warning`: `warn`,
+	`err`: `error`,
+	`error`: `error`,
+	`fatal`: `fatal`,
+	`panic`: `fatal`,
+}
+
+// levelRanks ranks the canonical level names for MatchLevel.
+var levelRanks = map[string]int{
+	`debug`: 0,
+	`info`: 1,
+	`warn`: 2,
+	`error`: 3,
+	`fatal`: 4,
+}
+
+// levelRe matches the level words in levelNames.
+var levelRe = regexp.MustCompile(`(?i)\b(trace|debug|info|warning|warn|error|err|fatal|panic)\b`)
+
+// ParseLevel returns the canonical name of a level word, ignoring case:
+// "debug", "info", "warn", "error" or "fatal". The words "trace",
+// "warning", "err" and "panic" are synonyms.
+func ParseLevel(word string) (string, bool) {
+	name, ok := levelNames[strings.ToLower(word)]
+	return name, ok
+}
+
+// FindLevel returns the canonical name of the first level word in a line,
+// or an empty string if the line has none. ConsoleWriter, MatchLevel and
+// logtail all recognize levels this way.
+func FindLevel(line []byte) string {
+	return levelNames[strings.ToLower(string(levelRe.Find(line)))]
+}
+
+// LineLevel returns the rank of the first level word in a line, from 0
+// for debug to 4 for fatal, or 1 (info) if the line has none.
+func LineLevel(line []byte) int {
+
+	if name := FindLevel(line); name != `` {
+		return levelRanks[name]
+	}
+
+	return levelRanks[`info`]
+}
+
+// MatchRegexp passes lines that match re.
+func MatchRegexp(re *regexp.Regexp) LineFilter {
+	return func(line []byte) bool {
+		return re.Match(line)
+	}
+}
+
+// MatchPrefix passes lines that begin with prefix.
+func MatchPrefix(prefix string) LineFilter {
+	return func(line []byte) bool {
+		return bytes.HasPrefix(line, []byte(prefix))
+	}
+}
+
+// MatchLevel passes lines whose level is at least min: "debug", "info",
+// "warn", "error" or "fatal".
+func MatchLevel(min string) (LineFilter, error) {
+
+	name, ok := ParseLevel(min)
+
+	if !ok {
+		return nil, fmt.Errorf(`unknown level %q`, min)
+	}
+
+	rank := levelRanks[name]
+
+	return func(line []byte) bool {
+		return LineLevel(line) >= rank
+	}, nil
+}
+
+// MatchAll passes lines that pass every filter.
+func MatchAll(filters ...LineFilter) LineFilter {
+	return func(line []byte) bool {
+		for _, f := range filters {
+			if !f(line) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Redact replaces every match of re with repl, which may refer to
+// submatches as regexp.Expand does.
+func Redact(re *regexp.Regexp, repl string) LineTransform {
+	return func(line []byte) []byte {
+		return re.ReplaceAll(line, []byte(repl))
+	}
+}
+
+// AddPrefix writes prefix before each line.
+func AddPrefix(prefix string) LineTransform {
+	return func(line []byte) []byte {
+		return append([]byte(prefix), line...)
+	}
+}
+
+// AddTimestamp writes the current time in the given layout, and a space,
+// before each line.
+func AddTimestamp(layout string) LineTransform {
+	return func(line []byte) []byte {
+		return append([]byte(time.Now().Format(layout) + ` `), line...)
+	}
+}
+
+// Reformat renders each line as the message of a system channel Record,
+// with the current time and the process's host, name and PID, in the
+// format of f. The line ending added by the formatter is dropped, as the
+// destination's framing supplies one.
+func Reformat(f RecordFormatter) LineTransform {
+
+	host, _ := os.Hostname()
+
+	if host == `` {
+		host = `localhost`
+	}
+
+	app, pid := filepath.Base(os.Args[0]), os.Getpid()
+
+	return func(line []byte) []byte {
+
+		r := &Record{
+			Time: time.Now(),
+			Channel: SystemChannel,
+			Host: host,
+			AppName: app,
+			PID: pid,
+			Message: string(line),
+		}
+
+		return bytes.TrimSuffix(f.Format(r), []byte("\n"))
+	}
+}
+
+// FilterConfig describes a LineFilter in JSON. Every non-empty field must
+// match for a line to pass.
+type FilterConfig struct {
+	Regexp string
+	Prefix string
+	Level string
+}
+
+// Build returns the filter, or nil if the configuration is empty.
+func (this FilterConfig) Build() (f LineFilter, err error) {
+
+	var filters []LineFilter
+
+	if this.Regexp != `` {
+		re, err := regexp.Compile(this.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, MatchRegexp(re))
+	}
+
+	if this.Prefix != `` {
+		filters = append(filters, MatchPrefix(this.Prefix))
+	}
+
+	if this.Level != `` {
+		lf, err := MatchLevel(this.Level)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, lf)
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	default:
+		return MatchAll(filters...), nil
+	}
+}
+
+// RedactConfig describes a Redact transform in JSON.
+type RedactConfig struct {
+	Pattern string
+	Replace string
+}
+
+// TransformConfig describes the transforms of a destination in JSON. They
+// are applied in the order redact, prefix, timestamp, reformat, so the
+// timestamp comes first in the line and the reformatted record holds the
+// whole line. Reformat is a format accepted by NewRecordFormatter.
+type TransformConfig struct {
+	Redact []RedactConfig
+	Prefix string
+	Timestamp string
+	Reformat string
+}
+
+// Build returns the transforms in the order they are applied.
+func (this TransformConfig) Build() (ts []LineTransform, err error) {
+
+	for _, r := range this.Redact {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, Redact(re, r.Replace))
+	}
+
+	if this.Prefix != `` {
+		ts = append(ts, AddPrefix(this.Prefix))
+	}
+
+	if this.Timestamp != `` {
+		ts = append(ts, AddTimestamp(this.Timestamp))
+	}
+
+	if this.Reformat != `` {
+		f, err := NewRecordFormatter(this.Reformat)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, Reformat(f))
+	}
+
+	return ts, nil
+}
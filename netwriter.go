@@ -0,0 +1,295 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`crypto/tls`
+	`fmt`
+	`net`
+	`sync`
+	`time`
+)
+
+// NetWriter defaults.
+const (
+	NetDialTimeout  = 5 * time.Second
+	NetWriteTimeout = 5 * time.Second
+	NetMinBackoff   = 100 * time.Millisecond
+	NetMaxBackoff   = 30 * time.Second
+	NetBacklogSize  = 1000
+)
+
+// NetWriter is an io.Writer that sends each write as one message to a
+// network destination. It dials on first use. While the destination is
+// unreachable, messages are held in a bounded backlog, oldest dropped
+// first, and redialing is retried with exponential backoff; the backlog
+// is sent, in order, once a connection succeeds.
+type NetWriter struct {
+	mu           sync.Mutex
+	network      string
+	address      string
+	tlsConfig    *tls.Config
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	backoff      time.Duration
+	nextDial     time.Time
+	conn         net.Conn
+	backlog      [][]byte
+	backlogSize  int
+	dropped      int64
+	onDrop       func()
+	lastErr      error
+}
+
+// NewNetWriter returns a NetWriter for a network of "tcp", "tcp4",
+// "tcp6", "udp", "udp4", "udp6", "unix", "unixgram" or "tcp+tls" and an
+// address in the form expected by net.Dial. No connection is made until
+// the first write.
+func NewNetWriter(network, address string) (this *NetWriter, err error) {
+
+	switch network {
+	case `tcp`, `tcp4`, `tcp6`, `udp`, `udp4`, `udp6`, `unix`, `unixgram`, `tcp+tls`:
+	default:
+		return nil, fmt.Errorf(`unsupported network %q`, network)
+	}
+
+	return &NetWriter{
+		network:      network,
+		address:      address,
+		dialTimeout:  NetDialTimeout,
+		writeTimeout: NetWriteTimeout,
+		minBackoff:   NetMinBackoff,
+		maxBackoff:   NetMaxBackoff,
+		backlogSize:  NetBacklogSize,
+	}, nil
+}
+
+// SetTLSConfig sets the TLS configuration used by "tcp+tls".
+func (this *NetWriter) SetTLSConfig(c *tls.Config) *NetWriter {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.tlsConfig = c
+	return this
+}
+
+// SetTimeouts sets the dial and write timeouts. Zero disables a timeout.
+func (this *NetWriter) SetTimeouts(dial, write time.Duration) *NetWriter {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.dialTimeout, this.writeTimeout = dial, write
+	return this
+}
+
+// SetBackoff sets the first and the longest delay between dial attempts.
+func (this *NetWriter) SetBackoff(min, max time.Duration) *NetWriter {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.minBackoff, this.maxBackoff = min, max
+	return this
+}
+
+// SetBacklog sets the number of messages held while disconnected.
+func (this *NetWriter) SetBacklog(n int) *NetWriter {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.backlogSize = n
+	this.trim()
+	return this
+}
+
+// OnDrop sets a function called, with the lock held, for each message
+// that is dropped.
+func (this *NetWriter) OnDrop(fn func()) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onDrop = fn
+}
+
+// String returns the destination as network://address.
+func (this *NetWriter) String() string {
+	return this.network + `://` + this.address
+}
+
+// Write sends b, or adds it to the backlog if the destination cannot be
+// reached. It returns an error only if the backlog is disabled and b could
+// not be sent.
+func (this *NetWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.backlog = append(this.backlog, append([]byte(nil), b...))
+	this.trim()
+
+	if err = this.flush(); err != nil && this.backlogSize <= 0 {
+		this.backlog = nil
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// flush dials if necessary and sends the backlog. A message that was
+// partly sent when the connection failed is dropped, and counted as
+// dropped, rather than sent again: its start may already have reached the
+// destination, and its remainder would begin the next connection's stream
+// mid-message. The caller must hold the lock.
+func (this *NetWriter) flush() (err error) {
+
+	if this.conn == nil {
+
+		if time.Now().Before(this.nextDial) {
+			return this.lastErr
+		}
+
+		if err = this.dial(); err != nil {
+			this.fail(err)
+			return err
+		}
+	}
+
+	for len(this.backlog) > 0 {
+
+		if this.writeTimeout > 0 {
+			this.conn.SetWriteDeadline(time.Now().Add(this.writeTimeout))
+		}
+
+		n, err := this.conn.Write(this.backlog[0])
+
+		if err != nil {
+			if n > 0 {
+				this.backlog[0] = nil
+				this.backlog = this.backlog[1:]
+				this.drop(1)
+			}
+			this.conn.Close()
+			this.conn = nil
+			this.fail(err)
+			return err
+		}
+
+		this.backlog[0] = nil
+		this.backlog = this.backlog[1:]
+	}
+
+	return nil
+}
+
+// dial connects to the destination. The caller must hold the lock.
+func (this *NetWriter) dial() (err error) {
+
+	dialer := &net.Dialer{Timeout: this.dialTimeout}
+
+	if this.network == `tcp+tls` {
+		this.conn, err = tls.DialWithDialer(dialer, `tcp`, this.address, this.tlsConfig)
+	} else {
+		this.conn, err = dialer.Dial(this.network, this.address)
+	}
+
+	if err != nil {
+		this.conn = nil
+		return err
+	}
+
+	this.backoff, this.lastErr = 0, nil
+
+	return nil
+}
+
+// fail records an error and schedules the next dial attempt. The caller
+// must hold the lock.
+func (this *NetWriter) fail(err error) {
+
+	switch {
+	case this.backoff == 0:
+		this.backoff = this.minBackoff
+	case this.backoff < this.maxBackoff:
+		this.backoff *= 2
+	}
+
+	if this.backoff > this.maxBackoff {
+		this.backoff = this.maxBackoff
+	}
+
+	this.nextDial = time.Now().Add(this.backoff)
+	this.lastErr = err
+}
+
+// trim drops the oldest messages beyond the backlog size. The caller must
+// hold the lock.
+func (this *NetWriter) trim() {
+
+	size := this.backlogSize
+
+	if size < 1 {
+		size = 1
+	}
+
+	if over := len(this.backlog) - size; over > 0 {
+		this.drop(over)
+		this.backlog = append([][]byte(nil), this.backlog[over:]...)
+	}
+}
+
+// drop counts n dropped messages. The caller must hold the lock.
+func (this *NetWriter) drop(n int) {
+
+	this.dropped += int64(n)
+
+	for ; this.onDrop != nil && n > 0; n-- {
+		this.onDrop()
+	}
+}
+
+// Connected reports whether a connection is open.
+func (this *NetWriter) Connected() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.conn != nil
+}
+
+// Backlog returns the number of messages waiting to be sent and the number
+// dropped because the backlog was full.
+func (this *NetWriter) Backlog() (waiting int, dropped int64) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return len(this.backlog), this.dropped
+}
+
+// Close closes the connection. Messages still in the backlog are sent
+// first if the destination can be reached.
+func (this *NetWriter) Close() (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if len(this.backlog) > 0 {
+		this.nextDial = time.Time{}
+		err = this.flush()
+	}
+
+	this.backlog = nil
+
+	if this.conn != nil {
+		if cerr := this.conn.Close(); err == nil {
+			err = cerr
+		}
+		this.conn = nil
+	}
+
+	return err
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bufio`
+	`fmt`
+	`net`
+	`testing`
+	`time`
+)
+
+// netReceiver accepts connections on a local listener and sends every
+// line received to a channel.
+type netReceiver struct {
+	ln net.Listener
+	lines chan string
+	conns chan net.Conn
+}
+
+// listenLocal starts a netReceiver on address, which may be
+// "127.0.0.1:0" for any free port.
+func listenLocal(t *testing.T, address string) (this *netReceiver) {
+
+	ln, err := net.Listen(`tcp`, address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	this = &netReceiver{ln, make(chan string, 100), make(chan net.Conn, 10)}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			this.conns <- conn
+			go func() {
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					this.lines <- sc.Text()
+				}
+			}()
+		}
+	}()
+
+	t.Cleanup(func() { ln.Close() })
+
+	return this
+}
+
+// expect reads the next lines received and compares them with want.
+func (this *netReceiver) expect(t *testing.T, want ...string) {
+
+	t.Helper()
+
+	for _, w := range want {
+		select {
+		case got := <-this.lines:
+			if got != w {
+				t.Fatalf(`got %q, want %q`, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf(`timed out waiting for %q`, w)
+		}
+	}
+}
+
+// newTestNetWriter returns a NetWriter for address with short backoff.
+func newTestNetWriter(t *testing.T, address string) *NetWriter {
+
+	nw, err := NewNetWriter(`tcp`, address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { nw.Close() })
+
+	return nw.SetBackoff(time.Millisecond, 10 * time.Millisecond)
+}
+
+// writeUntilConnected writes numbered messages with prefix until a connection is
+// open, returning the number written.
+func writeUntilConnected(t *testing.T, nw *NetWriter, prefix string) (n int) {
+
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); n++ {
+		if nw.Connected() {
+			return n
+		}
+		fmt.Fprintf(nw, "%s %d\n", prefix, n)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal(`no connection`)
+
+	return n
+}
+
+func TestNetWriterBacklog(t *testing.T) {
+
+	// Reserve an address, then leave it unreachable.
+
+	rcv := listenLocal(t, `127.0.0.1:0`)
+	address := rcv.ln.Addr().String()
+	rcv.ln.Close()
+
+	nw := newTestNetWriter(t, address)
+
+	for i := 1; i <= 3; i++ {
+		if _, err := fmt.Fprintf(nw, "queued %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if waiting, _ := nw.Backlog(); waiting != 3 || nw.Connected() {
+		t.Fatalf(`backlog %d, connected %v`, waiting, nw.Connected())
+	}
+
+	rcv = listenLocal(t, address)
+	time.Sleep(20 * time.Millisecond)
+
+	fmt.Fprintln(nw, `live`)
+
+	rcv.expect(t, `queued 1`, `queued 2`, `queued 3`, `live`)
+
+	if waiting, dropped := nw.Backlog(); waiting != 0 || dropped != 0 {
+		t.Errorf(`backlog %d, dropped %d after reconnect`, waiting, dropped)
+	}
+}
+
+func TestNetWriterBacklogLimit(t *testing.T) {
+
+	rcv := listenLocal(t, `127.0.0.1:0`)
+	address := rcv.ln.Addr().String()
+	rcv.ln.Close()
+
+	nw := newTestNetWriter(t, address).SetBacklog(2)
+
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(nw, "queued %d\n", i)
+	}
+
+	if waiting, dropped := nw.Backlog(); waiting != 2 || dropped != 3 {
+		t.Fatalf(`backlog %d, dropped %d`, waiting, dropped)
+	}
+
+	rcv = listenLocal(t, address)
+	time.Sleep(20 * time.Millisecond)
+	nw.Close()
+
+	rcv.expect(t, `queued 4`, `queued 5`)
+}
+
+func TestNetWriterReconnect(t *testing.T) {
+
+	rcv := listenLocal(t, `127.0.0.1:0`)
+	nw := newTestNetWriter(t, rcv.ln.Addr().String())
+
+	fmt.Fprintln(nw, `first`)
+	rcv.expect(t, `first`)
+
+	// Drop the connection from the server side. Writes fail once the
+	// client notices, and the writer redials.
+
+	(<-rcv.conns).Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for nw.Connected() && time.Now().Before(deadline) {
+		fmt.Fprintln(nw, `probe`)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	n := writeUntilConnected(t, nw, `retry`)
+
+	select {
+	case <-rcv.conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`writer did not redial`)
+	}
+
+	fmt.Fprintln(nw, `after`)
+
+	// The messages held while disconnected arrive in order on the new
+	// connection, possibly after the probe whose write failed.
+
+	var retries []string
+
+	for {
+		select {
+		case got := <-rcv.lines:
+			switch {
+			case got == `after`:
+				if len(retries) != n {
+					t.Fatalf(`received %d of %d held messages: %q`, len(retries), n, retries)
+				}
+				for i, r := range retries {
+					if want := fmt.Sprintf(`retry %d`, i); r != want {
+						t.Fatalf(`held message %d is %q, want %q`, i, r, want)
+					}
+				}
+				return
+			case got != `probe`:
+				retries = append(retries, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal(`message after reconnect not received`)
+		}
+	}
+}
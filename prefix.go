@@ -0,0 +1,184 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`runtime`
+	`strconv`
+	`strings`
+	`time`
+)
+
+// PrefixFormat describes the fields written before each log message.
+// TimeLayout is a Go time layout such as "2006-01-02T15:04:05.000Z07:00";
+// if empty no timestamp is written, except by a MultiLoggerWriter channel
+// whose log package flags select a date or time, which is then written in
+// the log package's layout. TimeZone is "Local", "UTC" or an IANA zone
+// name; if empty the local zone is used, or UTC if the channel's flags
+// include log.LUTC.
+type PrefixFormat struct {
+	TimeLayout string
+	TimeZone string
+	Host bool
+	PID bool
+	AppName bool
+	GoroutineID bool
+}
+
+// IsZero reports whether the format adds no fields.
+func (this PrefixFormat) IsZero() bool {
+	return this == PrefixFormat{}
+}
+
+// withFlags returns the format with an empty time layout and zone taken
+// from the date, time and UTC flags of the log package, so that the
+// timestamp those flags select is written in its place among the fields
+// rather than by the log package after them.
+func (this PrefixFormat) withFlags(flags int) PrefixFormat {
+
+	if this.TimeLayout == `` {
+
+		var layout []string
+
+		if flags & log.Ldate != 0 {
+			layout = append(layout, `2006/01/02`)
+		}
+
+		if flags & log.Lmicroseconds != 0 {
+			layout = append(layout, `15:04:05.000000`)
+		} else if flags & log.Ltime != 0 {
+			layout = append(layout, `15:04:05`)
+		}
+
+		this.TimeLayout = strings.Join(layout, ` `)
+	}
+
+	if this.TimeLayout != `` && this.TimeZone == `` && flags & log.LUTC != 0 {
+		this.TimeZone = `UTC`
+	}
+
+	return this
+}
+
+// PrefixWriter is an io.Writer that prepends a tag and the fields of a
+// PrefixFormat to each message, in the order tag, time, host, app[pid],
+// goroutine ID. It takes the place of the log package date and time flags
+// when those cannot express the required layout.
+type PrefixWriter struct {
+	w io.Writer
+	tag string
+	format PrefixFormat
+	loc *time.Location
+	host string
+	pid int
+	app string
+}
+
+// NewPrefixWriter returns a PrefixWriter that writes to w. The tag is
+// written first, exactly as given.
+func NewPrefixWriter(w io.Writer, tag, appName string, pf PrefixFormat) (this *PrefixWriter, err error) {
+
+	this = &PrefixWriter{
+		w: w,
+		tag: tag,
+		format: pf,
+		pid: os.Getpid(),
+		app: appName,
+	}
+
+	switch pf.TimeZone {
+	case ``, `Local`:
+		this.loc = time.Local
+	default:
+		if this.loc, err = time.LoadLocation(pf.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+
+	if this.host, err = os.Hostname(); err != nil {
+		this.host, err = `localhost`, nil
+	}
+
+	return this, nil
+}
+
+// Write prepends the prefix to b and writes the result in a single call
+// to the underlying writer. It returns len(b) on success.
+func (this *PrefixWriter) Write(b []byte) (n int, err error) {
+
+	bb := new(bytes.Buffer)
+	bb.WriteString(this.tag)
+
+	this.fields(bb, time.Now())
+
+	bb.Write(b)
+
+	if _, err = this.w.Write(bb.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// fields writes the prefix fields, other than the tag, for time t.
+func (this *PrefixWriter) fields(bb *bytes.Buffer, t time.Time) {
+
+	if this.format.TimeLayout != `` {
+		bb.WriteString(t.In(this.loc).Format(this.format.TimeLayout))
+		bb.WriteByte(' ')
+	}
+
+	if this.format.Host {
+		bb.WriteString(this.host)
+		bb.WriteByte(' ')
+	}
+
+	switch {
+	case this.format.AppName && this.format.PID:
+		fmt.Fprintf(bb, `%s[%d] `, this.app, this.pid)
+	case this.format.AppName:
+		bb.WriteString(this.app)
+		bb.WriteByte(' ')
+	case this.format.PID:
+		fmt.Fprintf(bb, `[%d] `, this.pid)
+	}
+
+	if this.format.GoroutineID {
+		fmt.Fprintf(bb, `goroutine=%d `, GoroutineID())
+	}
+}
+
+// GoroutineID returns the ID of the calling goroutine, parsed from the
+// first line of its stack trace, or zero if it cannot be determined.
+func GoroutineID() uint64 {
+
+	b := make([]byte, 64)
+	b = b[:runtime.Stack(b, false)]
+
+	b = bytes.TrimPrefix(b, []byte(`goroutine `))
+
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+
+	return id
+}
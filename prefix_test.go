@@ -0,0 +1,135 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`fmt`
+	`log`
+	`os`
+	`regexp`
+	`testing`
+	`time`
+)
+
+// prefixCases are the flags and prefix formats of a channel and the
+// output expected for the message "hello", with HOST and PID standing for
+// the host name and process ID.
+var prefixCases = []struct {
+	flags int
+	pf PrefixFormat
+	want string
+}{
+	// The time comes before the prefix fields, whether its layout is
+	// that of the log package flags or the prefix format's own.
+
+	{log.LstdFlags, PrefixFormat{Host: true, AppName: true, PID: true},
+		`^tag \d{4}/\d\d/\d\d \d\d:\d\d:\d\d HOST app\[PID\] hello\n$`},
+	{log.Ltime|log.Lmicroseconds, PrefixFormat{AppName: true},
+		`^tag \d\d:\d\d:\d\d\.\d{6} app hello\n$`},
+	{log.LstdFlags, PrefixFormat{TimeLayout: `2006-01-02T15:04:05Z07:00`, Host: true},
+		`^tag \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d[-+Z][0-9:]* HOST hello\n$`},
+	{log.LstdFlags|log.LUTC, PrefixFormat{TimeLayout: `15:04:05 MST`, PID: true},
+		`^tag \d\d:\d\d:\d\d UTC \[PID\] hello\n$`},
+	{log.LstdFlags|log.LUTC, PrefixFormat{TimeLayout: `15:04 MST`, TimeZone: `EST`, PID: true},
+		`^tag \d\d:\d\d EST \[PID\] hello\n$`},
+	{log.Lshortfile, PrefixFormat{AppName: true},
+		`^tag app prefix_test\.go:\d+: hello\n$`},
+	{0, PrefixFormat{Host: true},
+		`^tag HOST hello\n$`},
+	{log.LstdFlags, PrefixFormat{},
+		`^tag \d{4}/\d\d/\d\d \d\d:\d\d:\d\d hello\n$`},
+}
+
+// prefixRegexp compiles a prefixCases pattern.
+func prefixRegexp(pattern string) *regexp.Regexp {
+
+	host, err := os.Hostname()
+
+	if err != nil {
+		host = `localhost`
+	}
+
+	pattern = regexp.MustCompile(`HOST|PID`).ReplaceAllStringFunc(pattern, func(s string) string {
+		if s == `HOST` {
+			return regexp.QuoteMeta(host)
+		}
+		return fmt.Sprint(os.Getpid())
+	})
+
+	return regexp.MustCompile(pattern)
+}
+
+func TestChannelLoggerPrefix(t *testing.T) {
+
+	mlw := new(MultiLoggerWriter)
+	mlw.Config.AppName = `app`
+
+	for _, tc := range prefixCases {
+
+		bb := new(bytes.Buffer)
+		mlw.newLogger(bb, `tag `, tc.flags, tc.pf).Print(`hello`)
+
+		if !prefixRegexp(tc.want).Match(bb.Bytes()) {
+			t.Errorf(`flags %#x, %+v: got %q, want %s`, tc.flags, tc.pf, bb.String(), tc.want)
+		}
+	}
+}
+
+func TestTextFormatterPrefix(t *testing.T) {
+
+	for _, tc := range prefixCases {
+
+		tf, err := NewTextFormatter(`tag `, `app`, tc.flags, tc.pf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := &Record{Time: time.Now(), Message: `hello`}
+
+		if tc.flags & log.Lshortfile != 0 {
+			r.File, r.Line = `/src/prefix_test.go`, 1
+		}
+
+		if got := tf.Format(r); !prefixRegexp(tc.want).Match(got) {
+			t.Errorf(`flags %#x, %+v: got %q, want %s`, tc.flags, tc.pf, got, tc.want)
+		}
+	}
+}
+
+func TestTextFormatterUTC(t *testing.T) {
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.FixedZone(`X`, 3600))
+
+	for _, tc := range []struct {
+		flags int
+		want string
+	}{
+		{log.LstdFlags|log.LUTC, "tag 2026/01/02 02:04:05 hello\n"},
+		{log.Ldate|log.Lmicroseconds|log.LUTC, "tag 2026/01/02 02:04:05.000006 hello\n"},
+	} {
+
+		tf, err := NewTextFormatter(`tag `, `app`, tc.flags, PrefixFormat{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := string(tf.Format(&Record{Time: at, Message: `hello`})); got != tc.want {
+			t.Errorf(`flags %#x: got %q, want %q`, tc.flags, got, tc.want)
+		}
+	}
+}
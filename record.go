@@ -0,0 +1,389 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`encoding/json`
+	`fmt`
+	`io`
+	`log`
+	`os`
+	`path/filepath`
+	`strconv`
+	`strings`
+	`time`
+)
+
+const (
+	FormatText = `text`
+	FormatJSON = `json`
+	FormatRFC5424 = `rfc5424`
+)
+
+// SinkFormats names the format used by each sink of a channel. An empty
+// name is the same as FormatText.
+type SinkFormats struct {
+	File string
+	Console string
+	Syslog string
+}
+
+// Get returns the format of the named sink: "file", "console" or
+// "syslog".
+func (this SinkFormats) Get(sink string) string {
+
+	switch sink {
+	case `file`:
+		return this.File
+	case `console`:
+		return this.Console
+	case `syslog`:
+		return this.Syslog
+	}
+
+	return ``
+}
+
+// IsText reports whether every sink uses the text format.
+func (this SinkFormats) IsText() bool {
+
+	for _, f := range []string{this.File, this.Console, this.Syslog} {
+		if f != `` && f != FormatText {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidFormat reports whether name is a known sink format.
+func ValidFormat(name string) bool {
+
+	switch name {
+	case ``, FormatText, FormatJSON, FormatRFC5424:
+		return true
+	}
+
+	return false
+}
+
+// Record is one log message before it is formatted for a sink.
+type Record struct {
+	Time time.Time
+	Channel LogChannel
+	Tag string
+	Host string
+	AppName string
+	PID int
+	File string
+	Line int
+	Message string
+}
+
+// RecordFormatter renders a record for a sink. The result ends with a
+// newline.
+type RecordFormatter interface {
+	Format(r *Record) []byte
+}
+
+// RecordSink is implemented by writers that format records themselves.
+type RecordSink interface {
+	WriteRecord(r *Record) (n int, err error)
+}
+
+// NewRecordFormatter returns the formatter for a format other than text,
+// which depends on the channel's flags and prefix; see NewTextFormatter.
+func NewRecordFormatter(name string) (RecordFormatter, error) {
+
+	switch name {
+	case FormatJSON:
+		return JSONFormatter{}, nil
+	case FormatRFC5424:
+		return RFC5424Formatter{}, nil
+	}
+
+	return nil, fmt.Errorf(`unknown record format %q`, name)
+}
+
+// TextFormatter renders records as the channel logger would: the tag,
+// then the fields of a PrefixFormat, whose time is the date and time
+// selected by the log package flags unless it has a time layout, then the
+// file and line, then the message.
+type TextFormatter struct {
+	tag string
+	flags int
+	prefix *PrefixWriter
+}
+
+// NewTextFormatter returns a TextFormatter for a channel's tag, log
+// package flags and prefix format.
+func NewTextFormatter(tag, appName string, flags int, pf PrefixFormat) (this *TextFormatter, err error) {
+
+	this = &TextFormatter{tag: tag, flags: flags &^ loggerTimeFlags}
+
+	if pf = pf.withFlags(flags); !pf.IsZero() {
+		if this.prefix, err = NewPrefixWriter(nil, tag, appName, pf); err != nil {
+			return nil, err
+		}
+	}
+
+	return this, nil
+}
+
+// Format renders a record as text.
+func (this *TextFormatter) Format(r *Record) []byte {
+
+	bb := new(bytes.Buffer)
+	bb.WriteString(this.tag)
+
+	if this.prefix != nil {
+		this.prefix.fields(bb, r.Time)
+	}
+
+	if r.File != `` {
+		file := r.File
+		if this.flags & log.Lshortfile != 0 {
+			file = filepath.Base(file)
+		}
+		fmt.Fprintf(bb, `%s:%d: `, file, r.Line)
+	}
+
+	bb.WriteString(r.Message)
+	bb.WriteByte('\n')
+
+	return bb.Bytes()
+}
+
+// JSONFormatter renders records as JSON objects, one per line. The time
+// and msg keys match those of audit records so that both can be read by
+// the same tools.
+type JSONFormatter struct{}
+
+// Format renders a record as JSON.
+func (JSONFormatter) Format(r *Record) []byte {
+
+	obj := struct {
+		Time string `json:"time"`
+		Channel string `json:"channel"`
+		Tag string `json:"tag,omitempty"`
+		Host string `json:"host,omitempty"`
+		AppName string `json:"app,omitempty"`
+		PID int `json:"pid,omitempty"`
+		File string `json:"file,omitempty"`
+		Line int `json:"line,omitempty"`
+		Message string `json:"msg"`
+	}{
+		Time: r.Time.Format(time.RFC3339Nano),
+		Channel: r.Channel.String(),
+		Tag: r.Tag,
+		Host: r.Host,
+		AppName: r.AppName,
+		PID: r.PID,
+		File: r.File,
+		Line: r.Line,
+		Message: r.Message,
+	}
+
+	b, err := json.Marshal(obj)
+
+	if err != nil {
+		b = []byte(strconv.Quote(r.Message))
+	}
+
+	return append(b, '\n')
+}
+
+// RFC5424Formatter renders records as RFC 5424 syslog messages using the
+// local7 facility, with severity err for the error channel and info for
+// the others. The channel tag is used as the MSGID.
+type RFC5424Formatter struct{}
+
+// Format renders a record as an RFC 5424 message.
+func (RFC5424Formatter) Format(r *Record) []byte {
+
+	pri := SyslogPriInfo
+
+	if r.Channel == ErrorChannel {
+		pri = SyslogPriErr
+	}
+
+	nilvalue := func(s string) string {
+		if s = strings.Join(strings.Fields(s), `_`); s == `` {
+			return `-`
+		}
+		return s
+	}
+
+	procid := `-`
+
+	if r.PID > 0 {
+		procid = strconv.Itoa(r.PID)
+	}
+
+	msg := r.Message
+
+	if r.File != `` {
+		msg = fmt.Sprintf(`%s:%d: %s`, filepath.Base(r.File), r.Line, msg)
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s %s - %s\n",
+		pri,
+		r.Time.Format(time.RFC3339Nano),
+		nilvalue(r.Host),
+		nilvalue(r.AppName),
+		procid,
+		nilvalue(r.Tag),
+		msg,
+	))
+}
+
+// FormatWriter is a sink that renders records with its own formatter.
+// Bytes written to it directly are passed through unchanged.
+type FormatWriter struct {
+	w io.Writer
+	f RecordFormatter
+}
+
+// NewFormatWriter returns a FormatWriter that writes records formatted by
+// f to w.
+func NewFormatWriter(w io.Writer, f RecordFormatter) (this *FormatWriter) {
+	return &FormatWriter{w: w, f: f}
+}
+
+// Write writes b unchanged.
+func (this *FormatWriter) Write(b []byte) (n int, err error) {
+	return this.w.Write(b)
+}
+
+// WriteRecord formats r and writes it.
+func (this *FormatWriter) WriteRecord(r *Record) (n int, err error) {
+	return this.w.Write(this.f.Format(r))
+}
+
+// Unwrap returns the underlying writer.
+func (this *FormatWriter) Unwrap() io.Writer {
+	return this.w
+}
+
+// RecordWriter turns each write from a channel logger into a Record and
+// hands it to every sink: sinks that implement RecordSink format it
+// themselves and the others receive it rendered as text. The channel
+// logger should have no prefix and no flags other than the file flags,
+// which RecordWriter parses from the start of the message.
+type RecordWriter struct {
+	c LogChannel
+	tag string
+	host string
+	app string
+	pid int
+	files bool
+	text RecordFormatter
+	sinks []io.Writer
+}
+
+// NewRecordWriter returns a RecordWriter for a channel. The file argument
+// reports whether the logger writes the file and line before each
+// message.
+func NewRecordWriter(c LogChannel, tag, appName string, files bool, text RecordFormatter, sinks ...io.Writer) (this *RecordWriter) {
+
+	this = &RecordWriter{
+		c: c,
+		tag: strings.TrimSpace(tag),
+		app: appName,
+		pid: os.Getpid(),
+		files: files,
+		text: text,
+		sinks: sinks,
+	}
+
+	if this.host, _ = os.Hostname(); this.host == `` {
+		this.host = `localhost`
+	}
+
+	return this
+}
+
+// Write hands one message to every sink. All sinks are written even if
+// one fails; the first error is returned.
+func (this *RecordWriter) Write(b []byte) (n int, err error) {
+
+	r := &Record{
+		Time: time.Now(),
+		Channel: this.c,
+		Tag: this.tag,
+		Host: this.host,
+		AppName: this.app,
+		PID: this.pid,
+		Message: strings.TrimSuffix(string(b), "\n"),
+	}
+
+	if this.files {
+		r.File, r.Line, r.Message = splitFileLine(r.Message)
+	}
+
+	var text []byte
+
+	for _, w := range this.sinks {
+
+		var e error
+
+		if fw, ok := w.(*FailoverWriter); ok {
+			_, e = fw.WriteRecord(r, this.text)
+		} else if rs, ok := w.(RecordSink); ok {
+			_, e = rs.WriteRecord(r)
+		} else {
+			if text == nil {
+				text = this.text.Format(r)
+			}
+			_, e = w.Write(text)
+		}
+
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// splitFileLine separates the "file:line: " written by the log package
+// file flags from the rest of a message.
+func splitFileLine(s string) (file string, line int, msg string) {
+
+	i := strings.Index(s, `: `)
+
+	if i < 0 {
+		return ``, 0, s
+	}
+
+	j := strings.LastIndexByte(s[:i], ':')
+
+	if j < 0 {
+		return ``, 0, s
+	}
+
+	line, err := strconv.Atoi(s[j+1:i])
+
+	if err != nil {
+		return ``, 0, s
+	}
+
+	return s[:j], line, s[i+2:]
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`bytes`
+	`encoding/json`
+	`io/ioutil`
+	`log`
+	`path/filepath`
+	`regexp`
+	`strings`
+	`testing`
+	`time`
+)
+
+// testRecord returns a record with every field set.
+func testRecord() *Record {
+	return &Record{
+		Time: time.Date(2017, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Channel: SystemChannel,
+		Tag: `system`,
+		Host: `host`,
+		AppName: `app`,
+		PID: 123,
+		File: `/src/app/main.go`,
+		Line: 10,
+		Message: `disk "full"`,
+	}
+}
+
+func TestJSONFormatter(t *testing.T) {
+
+	b := JSONFormatter{}.Format(testRecord())
+
+	want := `{"time":"2017-01-02T03:04:05.000006Z","channel":"system","tag":"system",` +
+		`"host":"host","app":"app","pid":123,"file":"/src/app/main.go","line":10,` +
+		`"msg":"disk \"full\""}` + "\n"
+
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+
+	// Empty optional fields are omitted; time, channel and msg are not.
+
+	b = JSONFormatter{}.Format(&Record{Time: time.Unix(0, 0).UTC(), Channel: ErrorChannel, Message: "two\nlines"})
+	want = `{"time":"1970-01-01T00:00:00Z","channel":"error","msg":"two\nlines"}` + "\n"
+
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+}
+
+func TestRFC5424Formatter(t *testing.T) {
+
+	r := testRecord()
+
+	if got, want := string(RFC5424Formatter{}.Format(r)),
+		"<190>1 2017-01-02T03:04:05.000006Z host app 123 system - main.go:10: disk \"full\"\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	// The error channel has severity err, and empty or spaced header
+	// fields become the nil value or are joined.
+
+	r.Channel, r.Host, r.AppName, r.PID, r.Tag, r.File = ErrorChannel, ``, `my app`, 0, ``, ``
+
+	if got, want := string(RFC5424Formatter{}.Format(r)),
+		"<187>1 2017-01-02T03:04:05.000006Z - my_app - - - disk \"full\"\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestSplitFileLine(t *testing.T) {
+
+	for _, tc := range []struct {
+		in, file string
+		line int
+		msg string
+	}{
+		{`main.go:10: hello`, `main.go`, 10, `hello`},
+		{`/src/app/main.go:7: a: b`, `/src/app/main.go`, 7, `a: b`},
+		{`C:/src/main.go:3: x`, `C:/src/main.go`, 3, `x`},
+		{`no file here`, ``, 0, `no file here`},
+		{`key: value`, ``, 0, `key: value`},
+		{`main.go:x: hello`, ``, 0, `main.go:x: hello`},
+	} {
+		file, line, msg := splitFileLine(tc.in)
+		if file != tc.file || line != tc.line || msg != tc.msg {
+			t.Errorf(`%q: got %q, %d, %q`, tc.in, file, line, msg)
+		}
+	}
+}
+
+func TestRecordWriterSinks(t *testing.T) {
+
+	text, err := NewTextFormatter(`system `, `app`, log.Lshortfile, PrefixFormat{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jb, sb, tb := new(bytes.Buffer), new(bytes.Buffer), new(bytes.Buffer)
+
+	rw := NewRecordWriter(SystemChannel, `system `, `app`, true, text,
+		NewFormatWriter(jb, JSONFormatter{}),
+		NewFormatWriter(sb, RFC5424Formatter{}),
+		tb,
+	)
+
+	in := "/src/app/main.go:10: hello\n"
+
+	if n, err := rw.Write([]byte(in)); n != len(in) || err != nil {
+		t.Fatalf(`Write = %d, %v`, n, err)
+	}
+
+	var rec struct {
+		Channel string `json:"channel"`
+		File string `json:"file"`
+		Line int `json:"line"`
+		Msg string `json:"msg"`
+	}
+
+	if err = json.Unmarshal(jb.Bytes(), &rec); err != nil {
+		t.Fatalf(`%v in %q`, err, jb.String())
+	}
+
+	if rec.Channel != `system` || rec.File != `/src/app/main.go` || rec.Line != 10 || rec.Msg != `hello` {
+		t.Errorf(`JSON sink got %+v`, rec)
+	}
+
+	if !regexp.MustCompile(`^<190>1 \S+ \S+ app \d+ system - main\.go:10: hello\n$`).MatchString(sb.String()) {
+		t.Errorf(`RFC 5424 sink got %q`, sb.String())
+	}
+
+	if tb.String() != "system main.go:10: hello\n" {
+		t.Errorf(`text sink got %q`, tb.String())
+	}
+}
+
+func TestRecordModeSinkFormats(t *testing.T) {
+
+	dir := t.TempDir()
+	bb := new(lockedBuffer)
+
+	mlw := new(MultiLoggerWriter).
+		Defaults().
+		EnableConsole(false).
+		EnableSyslog(false).
+		LogDir(dir).
+		SystemLink(``).
+		ErrorLink(``).
+		SystemFormat(SinkFormats{File: FormatJSON}).
+		ErrorFormat(SinkFormats{File: FormatRFC5424}).
+		AddWriter(SystemChannel, bb).
+		Init()
+
+	mlw.GetLogger(SystemChannel).Print(`to json`)
+	mlw.GetLogger(ErrorChannel).Print(`to syslog format`)
+	mlw.Close()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, `system.log`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec struct {
+		Time time.Time `json:"time"`
+		Channel string `json:"channel"`
+		Msg string `json:"msg"`
+	}
+
+	if err = json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf(`system file is not JSON: %v in %q`, err, b)
+	}
+
+	if rec.Channel != `system` || rec.Msg != `to json` || rec.Time.IsZero() {
+		t.Errorf(`system file got %+v`, rec)
+	}
+
+	// The writer sink of the same channel still gets text.
+
+	if !strings.HasPrefix(bb.String(), `system `) || !strings.HasSuffix(bb.String(), "to json\n") {
+		t.Errorf(`text sink got %q`, bb.String())
+	}
+
+	if b, err = ioutil.ReadFile(filepath.Join(dir, `error.log`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`^<187>1 \S+ \S+ \S+ \d+ error - record_test\.go:\d+: to syslog format\n$`).Match(b) {
+		t.Errorf(`error file got %q`, b)
+	}
+}
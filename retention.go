@@ -0,0 +1,347 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`fmt`
+	`log`
+	`os`
+	`path/filepath`
+	`regexp`
+	`sort`
+	`strconv`
+	`strings`
+	`sync`
+	`time`
+)
+
+// RetentionPolicy limits the files kept for a log channel. MaxAge is a
+// duration such as "720h" or "30d"; MaxSize is the total size in bytes.
+// A zero value disables the corresponding limit.
+type RetentionPolicy struct {
+	MaxAge string
+	MaxFiles int
+	MaxSize int64
+}
+
+// IsZero reports whether the policy sets no limits.
+func (this RetentionPolicy) IsZero() bool {
+	return this.MaxAge == `` && this.MaxFiles == 0 && this.MaxSize == 0
+}
+
+// RetentionManager removes old log files according to a policy for each
+// channel. Files are selected with a glob pattern, so rotated and
+// compressed copies that share the log file's name prefix are included,
+// and may be narrowed with a regular expression, so that a pattern shared
+// with other channels or applications does not select their files. The
+// file currently being written is counted but never removed.
+type RetentionManager struct {
+	mu sync.Mutex
+	rules []*retentionRule
+	logger *log.Logger
+	stop chan struct{}
+	done chan struct{}
+}
+
+type retentionRule struct {
+	channel string
+	pattern string
+	match *regexp.Regexp
+	policy RetentionPolicy
+	maxAge time.Duration
+	current func() string
+}
+
+var templateActionRe = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// retentionSuffixRe matches what rotation, compression and relinking may
+// append to a log file's name: a RotateTimeLayout timestamp, or the number
+// or date suffix of logrotate, as in system.log.1, system.log.2.gz and
+// system.log-20260101, optionally followed by .gz.
+const retentionSuffixRe = `(\.\d{8}-\d{6}\.\d{3}(\.\d+)?|\.\d+|-\d{8}(\d{2})?(-\d+)?)?(\.gz)?`
+
+// NewRetentionManager returns an initialized RetentionManager that reports
+// removed files to logger. If logger is nil, removals are not reported.
+func NewRetentionManager(logger *log.Logger) (this *RetentionManager) {
+	return &RetentionManager{logger: logger}
+}
+
+// RetentionPattern converts log directory and file name templates into a
+// glob pattern matching the file and its rotated or compressed copies.
+func RetentionPattern(dir, name string) string {
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+
+	return templateActionRe.ReplaceAllString(name, `*`) + `*`
+}
+
+// RetentionRegexp converts log directory and file name templates into a
+// regular expression matching only the files of that log. The AppName and
+// Host fields must equal those in data, unless empty there; the Date and
+// PID fields must have their formats; and only the suffixes of rotated or
+// compressed copies may follow the name.
+func RetentionRegexp(dir, name string, data LogFileData) (*regexp.Regexp, error) {
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+
+	var (
+		bb strings.Builder
+		last int
+	)
+
+	for _, loc := range templateActionRe.FindAllStringIndex(name, -1) {
+		bb.WriteString(regexp.QuoteMeta(name[last:loc[0]]))
+		bb.WriteString(templateFieldRegexp(name[loc[0]+2:loc[1]-2], data))
+		last = loc[1]
+	}
+
+	bb.WriteString(regexp.QuoteMeta(name[last:]))
+
+	return regexp.Compile(`^` + bb.String() + retentionSuffixRe + `$`)
+}
+
+// templateFieldRegexp returns the regular expression for the value of a
+// template action. Actions other than a LogFileData field match any text
+// within a path element.
+func templateFieldRegexp(action string, data LogFileData) string {
+
+	var s string
+
+	switch strings.Trim(action, " \t-") {
+	case `.AppName`:
+		s = data.AppName
+	case `.Host`:
+		s = data.Host
+	case `.PID`:
+		return `\d+`
+	case `.Date`:
+		return `\d{4}-\d{2}-\d{2}`
+	}
+
+	if s == `` {
+		return `[^/\\]*`
+	}
+
+	return regexp.QuoteMeta(s)
+}
+
+// AddRule applies a policy to the files matching the glob pattern and, if
+// match is not nil, the regular expression. The current function, if not
+// nil, returns the path of the file in use, which is never removed.
+func (this *RetentionManager) AddRule(channel, pattern string, match *regexp.Regexp, p RetentionPolicy, current func() string) (err error) {
+
+	rule := &retentionRule{
+		channel: channel,
+		pattern: pattern,
+		match: match,
+		policy: p,
+		current: current,
+	}
+
+	if rule.maxAge, err = ParseAge(p.MaxAge); err != nil {
+		return err
+	}
+
+	if _, err = filepath.Match(pattern, ``); err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.rules = append(this.rules, rule)
+
+	return nil
+}
+
+// ParseAge parses a duration that may also use a "d" suffix for days.
+// An empty string yields zero.
+func ParseAge(s string) (time.Duration, error) {
+
+	if s == `` {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(s, `d`) {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, `d`), 64)
+		if err != nil {
+			return 0, fmt.Errorf(`invalid age %q`, s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// Enforce applies every rule once and returns the paths removed.
+func (this *RetentionManager) Enforce() (removed []string, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var errs int
+
+	for _, rule := range this.rules {
+		r, e := this.enforce(rule)
+		removed = append(removed, r...)
+		if e != nil {
+			reportError(ErrorDecorator(e))
+			errs++
+		}
+	}
+
+	if errs > 0 {
+		err = fmt.Errorf(`%d retention errors`, errs)
+	}
+
+	return removed, err
+}
+
+// enforce applies a single rule. The caller must hold the lock.
+func (this *RetentionManager) enforce(rule *retentionRule) (removed []string, err error) {
+
+	type logFile struct {
+		path string
+		info os.FileInfo
+	}
+
+	paths, err := filepath.Glob(rule.pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+
+	if rule.current != nil {
+		current = rule.current()
+	}
+
+	var files []logFile
+
+	for _, p := range paths {
+		if rule.match != nil && !rule.match.MatchString(p) {
+			continue
+		}
+		if fi, err := os.Lstat(p); err == nil && fi.Mode().IsRegular() {
+			files = append(files, logFile{p, fi})
+		}
+	}
+
+	// Newest first, so the files beyond the count and size limits are
+	// the oldest ones.
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+
+	var (
+		count int
+		total int64
+		now = time.Now()
+	)
+
+	for _, f := range files {
+
+		count++
+		total += f.info.Size()
+
+		if f.path == current {
+			continue
+		}
+
+		var reason string
+
+		switch {
+		case rule.maxAge > 0 && now.Sub(f.info.ModTime()) > rule.maxAge:
+			reason = fmt.Sprintf(`older than %s`, rule.policy.MaxAge)
+		case rule.policy.MaxFiles > 0 && count > rule.policy.MaxFiles:
+			reason = fmt.Sprintf(`more than %d files`, rule.policy.MaxFiles)
+		case rule.policy.MaxSize > 0 && total > rule.policy.MaxSize:
+			reason = fmt.Sprintf(`more than %d bytes`, rule.policy.MaxSize)
+		default:
+			continue
+		}
+
+		if e := os.Remove(f.path); e != nil {
+			err = e
+			continue
+		}
+
+		count--
+		total -= f.info.Size()
+		removed = append(removed, f.path)
+
+		if this.logger != nil {
+			this.logger.Printf(`retention: removed %s log file %s (%s)`, rule.channel, f.path, reason)
+		}
+	}
+
+	return removed, err
+}
+
+// Start enforces the rules immediately and then at every interval in the
+// background until Stop is called.
+func (this *RetentionManager) Start(interval time.Duration) {
+
+	this.mu.Lock()
+
+	if this.stop != nil {
+		this.mu.Unlock()
+		return
+	}
+
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+	stop, done := this.stop, this.done
+
+	this.mu.Unlock()
+
+	go func() {
+
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			this.Enforce()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop halts background enforcement and waits for it to finish.
+func (this *RetentionManager) Stop() {
+
+	this.mu.Lock()
+	stop, done := this.stop, this.done
+	this.stop, this.done = nil, nil
+	this.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`io/ioutil`
+	`os`
+	`path/filepath`
+	`sort`
+	`testing`
+	`time`
+)
+
+func TestRetentionSharedDir(t *testing.T) {
+
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+
+	// The system channel's pattern, *-*.log*, also matches the access
+	// channel's files and another application's.
+
+	files := []string{
+		`app-2026-01-01.log`,
+		`app-2026-01-01.log.20260101-235959.000.gz`,
+		`app-access-2026-01-01.log`,
+		`other-2026-01-01.log`,
+		`app-2026-01-02.log`,
+	}
+
+	for _, f := range files {
+
+		path := filepath.Join(dir, f)
+
+		if err := ioutil.WriteFile(path, []byte("line\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := LogFileData{AppName: `app`, Host: `host`}
+	rm := NewRetentionManager(nil)
+
+	rules := []struct{ channel, name string }{
+		{`system`, `{{.AppName}}-{{.Date}}.log`},
+		{`access`, `{{.AppName}}-access-{{.Date}}.log`},
+	}
+
+	current := filepath.Join(dir, `app-2026-01-02.log`)
+
+	for _, r := range rules {
+
+		match, err := RetentionRegexp(dir, r.name, data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := RetentionPolicy{MaxAge: `1d`}
+
+		if r.channel == `access` {
+			p = RetentionPolicy{MaxAge: `7d`}
+		}
+
+		cur := func() string { return current }
+
+		if err = rm.AddRule(r.channel, RetentionPattern(dir, r.name), match, p, cur); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := rm.Enforce()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range removed {
+		removed[i] = filepath.Base(removed[i])
+	}
+
+	sort.Strings(removed)
+
+	want := []string{
+		`app-2026-01-01.log`,
+		`app-2026-01-01.log.20260101-235959.000.gz`,
+	}
+
+	if len(removed) != len(want) || removed[0] != want[0] || removed[1] != want[1] {
+		t.Errorf(`removed %v, want %v`, removed, want)
+	}
+
+	for _, f := range []string{`app-access-2026-01-01.log`, `other-2026-01-01.log`, `app-2026-01-02.log`} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf(`%s removed: %v`, f, err)
+		}
+	}
+}
+
+func TestRetentionRegexpSuffixes(t *testing.T) {
+
+	match, err := RetentionRegexp(`/var/log`, `{{.AppName}}/system.log`, LogFileData{AppName: `app`})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		`system.log`: true,
+		`system.log.gz`: true,
+		`system.log.20260101-235959.000`: true,
+		`system.log.20260101-235959.000.2`: true,
+		`system.log.20260101-235959.000.10.gz`: true,
+		`system.log.1`: true,
+		`system.log.2.gz`: true,
+		`system.log-20260101`: true,
+		`system.log-20260101.gz`: true,
+		`system.log-2026010112`: true,
+		`system.log-20260101-1767225600`: true,
+		`system.log.bak`: false,
+		`system.log-old`: false,
+		`system.log.1.bz2`: false,
+		`system.logs`: false,
+		`system.log-2026`: false,
+	} {
+		if got := match.MatchString(`/var/log/app/` + name); got != want {
+			t.Errorf(`%s: match %v, want %v`, name, got, want)
+		}
+	}
+}
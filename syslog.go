@@ -0,0 +1,178 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goutil
+
+import (
+	`fmt`
+	`io`
+	`net`
+	`os`
+	`strings`
+	`sync`
+	`github.com/RackSec/srslog`
+)
+
+// SyslogWriter is implemented by the syslog sinks: srslog writers for
+// network destinations and LocalSyslogWriter for the local daemon.
+type SyslogWriter interface {
+	io.WriteCloser
+	SetFormatter(f srslog.Formatter)
+}
+
+// LocalSyslogSockets are the paths tried, in order, when the local syslog
+// socket is detected automatically.
+var LocalSyslogSockets = []string{`/dev/log`, `/var/run/syslog`, `/var/run/log`}
+
+// LocalSyslogWriter writes to the local syslog daemon through a unix
+// socket. Each message is sent as one datagram on a unixgram socket or
+// terminated by a NUL byte on a unix stream socket, as the C library's
+// syslog does. If a write fails, for example because the daemon was
+// restarted and recreated its socket, the writer reconnects and tries
+// once more.
+type LocalSyslogWriter struct {
+	mu sync.Mutex
+	network string
+	path string
+	pri srslog.Priority
+	tag string
+	hostname string
+	formatter srslog.Formatter
+	conn net.Conn
+	stream bool
+}
+
+// NewLocalSyslogWriter connects to the local syslog daemon. The network is
+// "unixgram", "unix", or "" or "local" to try unixgram and then unix. If
+// path is empty, each of LocalSyslogSockets is tried.
+func NewLocalSyslogWriter(network, path string, pri srslog.Priority, tag string) (this *LocalSyslogWriter, err error) {
+
+	switch network {
+	case ``, `local`, `unix`, `unixgram`:
+	default:
+		return nil, fmt.Errorf(`unsupported local syslog network %q`, network)
+	}
+
+	this = &LocalSyslogWriter{
+		network: network,
+		path: path,
+		pri: pri,
+		tag: tag,
+		formatter: srslog.UnixFormatter,
+	}
+
+	if this.tag == `` {
+		this.tag = os.Args[0]
+	}
+
+	if this.hostname, _ = os.Hostname(); this.hostname == `` {
+		this.hostname = `localhost`
+	}
+
+	if err = this.connect(); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// connect dials the socket, detecting the network and path if they were
+// not given. The caller must hold the lock, except during construction.
+func (this *LocalSyslogWriter) connect() (err error) {
+
+	networks := []string{this.network}
+
+	if this.network == `` || this.network == `local` {
+		networks = []string{`unixgram`, `unix`}
+	}
+
+	paths := []string{this.path}
+
+	if this.path == `` {
+		paths = LocalSyslogSockets
+	}
+
+	for _, p := range paths {
+		for _, n := range networks {
+			var conn net.Conn
+			if conn, err = net.Dial(n, p); err == nil {
+				this.conn, this.stream = conn, n == `unix`
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf(`local syslog: %v`, err)
+}
+
+// SetFormatter sets the function that renders each message. The default
+// is srslog.UnixFormatter.
+func (this *LocalSyslogWriter) SetFormatter(f srslog.Formatter) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.formatter = f
+}
+
+// Write sends b as one message, reconnecting once if the write fails.
+func (this *LocalSyslogWriter) Write(b []byte) (n int, err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	msg := this.formatter(this.pri, this.hostname, this.tag, strings.TrimRight(string(b), "\n"))
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		if this.conn == nil {
+			if err = this.connect(); err != nil {
+				continue
+			}
+		}
+
+		if err = this.send(msg); err == nil {
+			return len(b), nil
+		}
+
+		this.conn.Close()
+		this.conn = nil
+	}
+
+	return 0, err
+}
+
+// send frames and writes one message.
+func (this *LocalSyslogWriter) send(msg string) (err error) {
+
+	if this.stream {
+		msg += "\x00"
+	}
+
+	_, err = io.WriteString(this.conn, msg)
+
+	return err
+}
+
+// Close closes the connection.
+func (this *LocalSyslogWriter) Close() (err error) {
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.conn != nil {
+		err = this.conn.Close()
+		this.conn = nil
+	}
+
+	return err
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 John Scherff
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package goutil
+
+import (
+	`bufio`
+	`fmt`
+	`net`
+	`os`
+	`path/filepath`
+	`testing`
+	`time`
+	`github.com/RackSec/srslog`
+)
+
+// testSyslogFormatter renders messages independently of srslog's
+// formatters, which add timestamps.
+func testSyslogFormatter(p srslog.Priority, hostname, tag, content string) string {
+	return fmt.Sprintf(`<%d>%s: %s`, p, tag, content)
+}
+
+// listenSyslog listens for datagrams on a socket in a temporary directory.
+func listenSyslog(t *testing.T, path string) *net.UnixConn {
+
+	conn, err := net.ListenUnixgram(`unixgram`, &net.UnixAddr{Name: path, Net: `unixgram`})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+// readSyslog returns the next datagram received on conn.
+func readSyslog(t *testing.T, conn *net.UnixConn) string {
+
+	b := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	n, err := conn.Read(b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b[:n])
+}
+
+func TestLocalSyslogWriterUnixgram(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `log`)
+	conn := listenSyslog(t, path)
+	defer conn.Close()
+
+	// An empty network detects the socket type.
+
+	sw, err := NewLocalSyslogWriter(``, path, SyslogPriInfo, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sw.Close()
+
+	sw.SetFormatter(testSyslogFormatter)
+
+	if _, err = sw.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf(`<%d>app: hello`, SyslogPriInfo)
+
+	if got := readSyslog(t, conn); got != want {
+		t.Errorf(`got %q, want %q`, got, want)
+	}
+
+	// The daemon restarts and recreates its socket; the next write
+	// reconnects.
+
+	conn.Close()
+	os.Remove(path)
+
+	conn = listenSyslog(t, path)
+	defer conn.Close()
+
+	if _, err = sw.Write([]byte("again\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want = fmt.Sprintf(`<%d>app: again`, SyslogPriInfo)
+
+	if got := readSyslog(t, conn); got != want {
+		t.Errorf(`after restart got %q, want %q`, got, want)
+	}
+}
+
+func TestLocalSyslogWriterUnixStream(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), `log`)
+	ln, err := net.Listen(`unix`, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	sw, err := NewLocalSyslogWriter(`unix`, path, SyslogPriInfo, `app`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sw.Close()
+
+	sw.SetFormatter(testSyslogFormatter)
+
+	conn, err := ln.Accept()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, m := range []string{"one\n", "two\n"} {
+		if _, err = sw.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Messages on a stream socket are terminated by NUL.
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(conn)
+
+	for _, m := range []string{`one`, `two`} {
+
+		got, err := rd.ReadString(0)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := fmt.Sprintf("<%d>app: %s\x00", SyslogPriInfo, m); got != want {
+			t.Errorf(`got %q, want %q`, got, want)
+		}
+	}
+}
+
+func TestLocalSyslogConfig(t *testing.T) {
+
+	mlw := new(MultiLoggerWriter).Defaults().
+		SyslogProt(`unixgram`).
+		SyslogHost(`loghost`).
+		SyslogPort(`514`)
+
+	var paths []string
+
+	for _, ce := range mlw.CheckConfig() {
+		paths = append(paths, ce.Path)
+	}
+
+	if len(paths) != 2 || paths[0] != `Config.Syslog.Host` || paths[1] != `Config.Syslog.Port` {
+		t.Errorf(`got errors for %v, want Host and Port`, paths)
+	}
+}